@@ -4,9 +4,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/threatlevelmidnight10/devspec/internal/executor"
+	"github.com/threatlevelmidnight10/devspec/internal/issue"
 	"github.com/threatlevelmidnight10/devspec/internal/spec"
 )
 
@@ -25,6 +28,8 @@ func run(args []string) error {
 	switch args[0] {
 	case "run":
 		return runCommand(args[1:])
+	case "prompt":
+		return promptCommand(args[1:])
 	default:
 		return usageError()
 	}
@@ -39,46 +44,198 @@ func runCommand(args []string) error {
 	fs := flag.NewFlagSet("run", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 
-	var task string
+	var tf taskFlags
 	var dryRun bool
 	var noPR bool
 	var keepWorkspace bool
 	var modelOverride string
 	var maxIterOverride int
+	var onDirty string
+	var branch string
 
-	fs.StringVar(&task, "task", "", "task description to execute")
+	tf.register(fs)
 	fs.BoolVar(&dryRun, "dry-run", false, "show what would run without changing git state")
 	fs.BoolVar(&noPR, "no-pr", false, "skip PR creation regardless of spec output settings")
 	fs.BoolVar(&keepWorkspace, "keep-workspace", false, "do not delete the temporary Cursor workspace directory after run")
 	fs.StringVar(&modelOverride, "model", "", "override orchestrator model from spec")
 	fs.IntVar(&maxIterOverride, "max-iter", 0, "override max iteration constraint")
+	fs.StringVar(&branch, "branch", "", "continue an existing agent branch instead of creating a new one")
+	fs.StringVar(&onDirty, "on-dirty", "", "how to handle uncommitted changes: abort, stash, worktree or include (default: prompt on a terminal, abort otherwise)")
 
 	if err := fs.Parse(args[1:]); err != nil {
 		return err
 	}
-	if task == "" {
-		return fmt.Errorf("--task is required")
+	opts, err := tf.options(branch != "")
+	if err != nil {
+		return err
 	}
-
-	s, err := spec.Load(specPath)
+	dirtyStrategy, err := executor.ParseDirtyStrategy(onDirty)
 	if err != nil {
 		return err
 	}
 
-	r := executor.Runner{
-		Spec: s,
-		Opts: executor.Options{
-			Task:            task,
-			DryRun:          dryRun,
-			NoPR:            noPR,
-			KeepWorkspace:   keepWorkspace,
-			ModelOverride:   modelOverride,
-			MaxIterOverride: maxIterOverride,
-		},
+	s, err := loadSpec(specPath)
+	if err != nil {
+		return err
 	}
+
+	opts.DryRun = dryRun
+	opts.NoPR = noPR
+	opts.KeepWorkspace = keepWorkspace
+	opts.ModelOverride = modelOverride
+	opts.MaxIterOverride = maxIterOverride
+	opts.OnDirty = dirtyStrategy
+	opts.Branch = strings.TrimSpace(branch)
+	r := executor.Runner{Spec: s, Opts: opts}
 	return r.Run(context.Background())
 }
 
+func promptCommand(args []string) error {
+	if len(args) == 0 || args[0] != "render" {
+		return usageError()
+	}
+	args = args[1:]
+	if len(args) == 0 {
+		return fmt.Errorf("missing spec path\n\n%s", usage())
+	}
+
+	specPath := args[0]
+	fs := flag.NewFlagSet("prompt render", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+
+	var tf taskFlags
+	var step string
+	var planFile string
+	var diffFile string
+
+	tf.register(fs)
+	fs.StringVar(&step, "step", "", "render only the prompt of this step")
+	fs.StringVar(&planFile, "plan-output", "", "file standing in for the output of the plan step")
+	fs.StringVar(&diffFile, "diff", "", "file standing in for the diff the self_review step sees (default: the working tree diff)")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	opts, err := tf.options(false)
+	if err != nil {
+		return err
+	}
+	preview := executor.PreviewOptions{Step: step}
+	if planFile != "" {
+		b, err := os.ReadFile(planFile)
+		if err != nil {
+			return fmt.Errorf("read plan output: %w", err)
+		}
+		preview.PlanOutput = string(b)
+	}
+	if diffFile != "" {
+		b, err := os.ReadFile(diffFile)
+		if err != nil {
+			return fmt.Errorf("read diff: %w", err)
+		}
+		preview.Diff = string(b)
+	}
+
+	s, err := loadSpec(specPath)
+	if err != nil {
+		return err
+	}
+	r := executor.Runner{Spec: s, Opts: opts}
+	return r.RenderPrompts(context.Background(), os.Stdout, preview)
+}
+
+func loadSpec(path string) (*spec.Spec, error) {
+	s, err := spec.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	global, err := spec.LoadGlobal()
+	if err != nil {
+		return nil, err
+	}
+	s.ApplyGlobal(global)
+	return s, nil
+}
+
+// taskFlags are the flags giving a run its task and review feedback.
+type taskFlags struct {
+	task         string
+	taskFile     string
+	feedback     string
+	feedbackFile string
+}
+
+func (tf *taskFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.task, "task", "", "task description to execute, or - to read it from stdin")
+	fs.StringVar(&tf.taskFile, "task-file", "", "read the task from a file, e.g. a markdown issue with frontmatter")
+	fs.StringVar(&tf.feedback, "feedback", "", "review feedback to address, passed to the agents alongside the task")
+	fs.StringVar(&tf.feedbackFile, "feedback-file", "", "read review feedback from a file")
+}
+
+// options reads the task and feedback. When continuing a branch, the
+// feedback can stand in for the task.
+func (tf *taskFlags) options(continuing bool) (executor.Options, error) {
+	var opts executor.Options
+	feedback := tf.feedback
+	if tf.feedbackFile != "" {
+		if feedback != "" {
+			return opts, fmt.Errorf("--feedback and --feedback-file are mutually exclusive")
+		}
+		b, err := os.ReadFile(tf.feedbackFile)
+		if err != nil {
+			return opts, fmt.Errorf("read feedback: %w", err)
+		}
+		feedback = string(b)
+	}
+	task := tf.task
+	iss, err := readTask(task, tf.taskFile)
+	if err != nil {
+		return opts, err
+	}
+	if iss != nil {
+		task = iss.Task()
+		opts.Title = iss.Title
+		opts.Labels = iss.Labels
+		opts.Acceptance = iss.Acceptance
+	}
+	if task == "" && continuing && strings.TrimSpace(feedback) != "" {
+		// When iterating on review feedback, the feedback is the task.
+		task = feedback
+		feedback = ""
+	}
+	if task == "" {
+		return opts, fmt.Errorf("--task or --task-file is required")
+	}
+	opts.Task = task
+	opts.Feedback = feedback
+	return opts, nil
+}
+
+// readTask reads a task given with --task - or --task-file as an issue.
+// It returns nil for a task given inline with --task.
+func readTask(task, taskFile string) (*issue.Issue, error) {
+	var raw []byte
+	var err error
+	switch {
+	case taskFile != "" && task != "":
+		return nil, fmt.Errorf("--task and --task-file are mutually exclusive")
+	case taskFile != "":
+		raw, err = os.ReadFile(taskFile)
+	case task == "-":
+		raw, err = io.ReadAll(os.Stdin)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read task: %w", err)
+	}
+	iss, err := issue.Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("task: %w", err)
+	}
+	return iss, nil
+}
+
 func usageError() error {
 	return fmt.Errorf("%s", usage())
 }
@@ -87,6 +244,10 @@ func usage() string {
 	return `devspec - deterministic agent workflow runner
 
 Usage:
-  devspec run <spec.yaml> --task "..." [--dry-run] [--no-pr] [--keep-workspace] [--model override-model] [--max-iter N]
+  devspec run <spec.yaml> (--task "..." | --task - | --task-file issue.md) [--dry-run] [--no-pr] [--keep-workspace] [--model override-model] [--max-iter N]
+                             [--on-dirty abort|stash|worktree|include]
+                             [--branch agent/existing-branch] [--feedback "..." | --feedback-file path]
+  devspec prompt render <spec.yaml> (--task "..." | --task - | --task-file issue.md) [--step name]
+                             [--plan-output plan.md] [--diff changes.diff] [--feedback "..." | --feedback-file path]
 `
 }
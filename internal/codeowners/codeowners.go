@@ -0,0 +1,164 @@
+// Package codeowners reads CODEOWNERS files and finds the owners of paths.
+package codeowners
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Locations lists where CODEOWNERS files are looked up, relative to the
+// repository root, in order.
+var Locations = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"}
+
+type rule struct {
+	pattern string
+	re      *regexp.Regexp
+	owners  []string
+}
+
+// File is a parsed CODEOWNERS file. As in GitHub and GitLab, the last rule
+// matching a path wins.
+type File struct {
+	rules []rule
+}
+
+// Load reads the first CODEOWNERS file found in the repository at root. It
+// returns nil, nil if the repository has none.
+func Load(root string) (*File, error) {
+	for _, loc := range Locations {
+		f, err := os.Open(filepath.Join(root, loc))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		cf, err := Parse(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", loc, err)
+		}
+		return cf, nil
+	}
+	return nil, nil
+}
+
+func Parse(r io.Reader) (*File, error) {
+	var f File
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// GitLab sections, e.g. "[Docs]" or "^[Docs] @team".
+		if strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		re, err := Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		var owners []string
+		if len(fields) > 1 {
+			owners = fields[1:]
+		}
+		f.rules = append(f.rules, rule{pattern: fields[0], re: re, owners: owners})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Owners returns the owners of path, a slash-separated path relative to
+// the repository root. A matching rule without owners un-owns the path.
+func (f *File) Owners(path string) []string {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "/")
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		if f.rules[i].re.MatchString(path) {
+			return f.rules[i].owners
+		}
+	}
+	return nil
+}
+
+// OwnersOf returns the owners of any of paths, deduplicated, in the order
+// they are first found.
+func (f *File) OwnersOf(paths []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, p := range paths {
+		for _, o := range f.Owners(p) {
+			if !seen[o] {
+				seen[o] = true
+				out = append(out, o)
+			}
+		}
+	}
+	return out
+}
+
+// Compile turns a CODEOWNERS pattern, which follows .gitignore rules, into
+// a regular expression matching slash-separated paths. A pattern that
+// matches a directory matches everything below it, except that, as on
+// GitHub, a wildcard in the last segment only matches direct children.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	p := pattern
+	dirOnly := strings.HasSuffix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	// A slash anywhere but at the end anchors the pattern to the root.
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil, fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case c == '*' && strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(p[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(p):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(p[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	last := p[strings.LastIndex(p, "/")+1:]
+	switch {
+	case dirOnly:
+		b.WriteString("/.*$")
+	case strings.Contains(last, "*") && last != "**":
+		b.WriteString("$")
+	default:
+		b.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,72 @@
+package codeowners
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "internal/x/y.go", true},
+		{"*.go", "main.gox", false},
+		{"/build/", "build/out.txt", true},
+		{"/build/", "src/build/out.txt", false},
+		{"docs/", "docs/a/b.md", true},
+		{"docs", "src/docs/readme.md", true},
+		{"apps/*", "apps/web", true},
+		{"apps/*", "apps/web/index.js", false},
+		{"apps/*.js", "apps/web/index.js", false},
+		{"**/logs", "a/b/logs/today.log", true},
+		{"/db/**/migrations/", "db/v2/migrations/001.sql", true},
+		{"/db/**/migrations/", "db/migrations/001.sql", true},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+	}
+	for _, c := range cases {
+		re, err := Compile(c.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", c.pattern, err)
+		}
+		if got := re.MatchString(c.path); got != c.want {
+			t.Errorf("%q matching %q = %v, want %v (%s)", c.pattern, c.path, got, c.want, re)
+		}
+	}
+}
+
+func TestOwnersLastMatchWins(t *testing.T) {
+	f, err := Parse(strings.NewReader(`
+# global owners
+*                @org/core
+*.sql            @dba alice@example.com
+/docs/           @org/docs # writers
+/docs/generated/
+[Frontend]
+/web/            @org/frontend
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]string{
+		"main.go":               {"@org/core"},
+		"db/001.sql":            {"@dba", "alice@example.com"},
+		"docs/guide.md":         {"@org/docs"},
+		"docs/generated/api.md": nil,
+		"web/app.tsx":           {"@org/frontend"},
+	}
+	for path, want := range cases {
+		if got := f.Owners(path); !reflect.DeepEqual(got, want) {
+			t.Errorf("Owners(%q) = %v, want %v", path, got, want)
+		}
+	}
+	got := f.OwnersOf([]string{"main.go", "db/001.sql", "cmd/main.go"})
+	want := []string{"@org/core", "@dba", "alice@example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OwnersOf = %v, want %v", got, want)
+	}
+}
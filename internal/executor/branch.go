@@ -0,0 +1,156 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// maxBranchSuffix bounds the -2, -3, ... suffixes tried when a branch name is
+// already taken.
+const maxBranchSuffix = 99
+
+// branchData is the data available to workspace.branch_template.
+type branchData struct {
+	Prefix    string
+	Name      string
+	TaskSlug  string
+	Ticket    string
+	User      string
+	Date      string
+	Time      string
+	Timestamp string
+	ShortHash string
+}
+
+// newBranchName renders the agent branch name, validates it and, outside of
+// dry runs, appends the first free numeric suffix if a repo already has a
+// branch by that name locally or on its push remote.
+func (r *Runner) newBranchName(ctx context.Context, st *runState) (string, error) {
+	var name string
+	if strings.TrimSpace(r.Spec.Workspace.BranchTemplate) == "" {
+		name = makeBranchName(r.Spec.Workspace.BranchPref, r.Spec.Name, r.Now())
+	} else {
+		var err error
+		name, err = renderBranchName(r.Spec.Workspace.BranchTemplate, r.branchData(ctx, st))
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(st.repos) == 0 {
+		return name, nil
+	}
+	if err := gitutil.CheckBranchName(ctx, st.repos[0].path, name); err != nil {
+		return "", err
+	}
+	if r.Opts.DryRun {
+		return name, nil
+	}
+
+	for n := 1; n <= maxBranchSuffix; n++ {
+		candidate := name
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, n)
+		}
+		taken, err := branchTaken(ctx, st.repos, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("branch %q and its suffixes up to -%d already exist", name, maxBranchSuffix)
+}
+
+func branchTaken(ctx context.Context, repos []repoState, branch string) (bool, error) {
+	for _, rs := range repos {
+		local, err := gitutil.BranchExists(ctx, rs.path, branch)
+		if err != nil {
+			return false, fmt.Errorf("repo %q: %w", rs.spec.Name, err)
+		}
+		if local {
+			return true, nil
+		}
+		remote, err := gitutil.RemoteBranchExists(ctx, rs.path, rs.spec.PushRemote, branch)
+		if err != nil {
+			return false, fmt.Errorf("repo %q: %w", rs.spec.Name, err)
+		}
+		if remote {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *Runner) branchData(ctx context.Context, st *runState) branchData {
+	ts := r.Now().UTC()
+	sum := sha256.Sum256([]byte(r.Spec.Name + "\n" + r.Opts.Task))
+	data := branchData{
+		Prefix:    strings.Trim(strings.TrimSpace(r.Spec.Workspace.BranchPref), "/"),
+		Name:      r.Spec.Name,
+		TaskSlug:  slug(r.taskTitle(), 40),
+		Date:      ts.Format("20060102"),
+		Time:      ts.Format("150405"),
+		Timestamp: ts.Format("20060102-150405"),
+		ShortHash: hex.EncodeToString(sum[:])[:7],
+	}
+	if re, err := regexp.Compile(r.Spec.Workspace.TicketPattern); err == nil {
+		data.Ticket = re.FindString(r.Opts.Task)
+	}
+	if len(st.repos) > 0 {
+		if id, err := gitutil.UserIdentity(ctx, st.repos[0].path); err == nil {
+			data.User = id.Name
+		}
+	}
+	if data.User == "" {
+		data.User = os.Getenv("USER")
+	}
+	return data
+}
+
+// renderBranchName executes a branch template and applies the sanitizeName
+// rules to every path segment of the result, dropping empty ones.
+func renderBranchName(text string, data branchData) (string, error) {
+	tmpl, err := template.New("branch").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse workspace.branch_template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render workspace.branch_template: %w", err)
+	}
+	var segs []string
+	for _, seg := range strings.Split(buf.String(), "/") {
+		seg = strings.TrimSuffix(sanitizeSegment(seg), ".lock")
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	if len(segs) == 0 {
+		return "", fmt.Errorf("workspace.branch_template rendered an empty branch name")
+	}
+	return strings.Join(segs, "/"), nil
+}
+
+// slug sanitizes s and shortens it to at most max characters, cutting at a
+// word boundary when possible.
+func slug(s string, max int) string {
+	s = sanitizeSegment(s)
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	if i := strings.LastIndex(s, "-"); i > max/2 {
+		s = s[:i]
+	}
+	return strings.Trim(s, "-./")
+}
@@ -0,0 +1,271 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+	"github.com/threatlevelmidnight10/devspec/internal/orchestrator"
+	"github.com/threatlevelmidnight10/devspec/internal/prompt"
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+const defaultCommitMessage = "devspec: {{.Spec}}{{if .Step}} ({{.Step}}){{end}}"
+
+// commitData is the data available to commit.message templates.
+type commitData struct {
+	Spec   string
+	Title  string
+	Task   string
+	Step   string
+	Agent  string
+	Model  string
+	Repo   string
+	Branch string
+	RunID  string
+}
+
+// commitRepo stages and commits pending changes in rs. step is nil for the
+// final commit of a run. It reports whether a commit was made.
+func (r *Runner) commitRepo(ctx context.Context, st *runState, rs *repoState, step *spec.Step) (bool, error) {
+	if err := gitutil.AddAll(ctx, rs.path); err != nil {
+		return false, err
+	}
+	staged, err := gitutil.HasStagedChanges(ctx, rs.path)
+	if err != nil || !staged {
+		return false, err
+	}
+	msg, err := r.commitMessage(ctx, st, rs, step)
+	if err != nil {
+		return false, err
+	}
+	if err := gitutil.Commit(ctx, rs.path, msg, r.commitOptions()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Runner) commitOptions() gitutil.CommitOptions {
+	c := r.Spec.Commit
+	opts := gitutil.CommitOptions{
+		Author:    gitutil.Identity{Name: c.Author.Name, Email: c.Author.Email},
+		Committer: gitutil.Identity{Name: c.Committer.Name, Email: c.Committer.Email},
+	}
+	if c.Committer.IsZero() {
+		opts.Committer = opts.Author
+	}
+	if c.Sign.Enabled() {
+		opts.Sign = true
+		opts.SigningKey = c.Sign.Key
+		opts.SignProgram = c.Sign.Program
+		switch c.Sign.Format {
+		case "gpg":
+			opts.SignFormat = "openpgp"
+		case "ssh", "x509":
+			opts.SignFormat = c.Sign.Format
+		}
+	}
+	return opts
+}
+
+// coAuthors returns the Co-authored-by trailer values for commits in rs.
+func (r *Runner) coAuthors(ctx context.Context, rs *repoState) []string {
+	var out []string
+	if r.Spec.Commit.CoAuthorGitUser {
+		id, err := gitutil.UserIdentity(ctx, rs.path)
+		if err == nil && id.Name != "" && id.Email != "" && id.Email != r.Spec.Commit.Author.Email {
+			out = append(out, fmt.Sprintf("%s <%s>", id.Name, id.Email))
+		}
+	}
+	for _, co := range r.Spec.Commit.CoAuthors {
+		if co = strings.TrimSpace(co); co != "" {
+			out = append(out, co)
+		}
+	}
+	return out
+}
+
+// commitStep commits the changes made by step in every repo when
+// commit.per_step is enabled.
+func (r *Runner) commitStep(ctx context.Context, st *runState, step spec.Step) error {
+	if !r.Spec.Commit.PerStep || r.Opts.DryRun || step.Mode == "plan" {
+		return nil
+	}
+	for i := range st.repos {
+		rs := &st.repos[i]
+		committed, err := r.commitRepo(ctx, st, rs, &step)
+		if err != nil {
+			return fmt.Errorf("repo %q commit step %s: %w", rs.spec.Name, step.Name, err)
+		}
+		if committed {
+			fmt.Printf("  committed %s in %s\n", step.Name, rs.spec.Name)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) commitMessage(ctx context.Context, st *runState, rs *repoState, step *spec.Step) (string, error) {
+	data := commitData{
+		Spec:   r.Spec.Name,
+		Title:  r.taskTitle(),
+		Task:   r.Opts.Task,
+		Repo:   rs.spec.Name,
+		Branch: st.branchName,
+		RunID:  st.runID,
+		Model:  r.Spec.EffectiveModel(r.Opts.ModelOverride),
+	}
+	if step != nil {
+		data.Step = step.Name
+		data.Agent = step.Agent
+		if step.Agent != "" {
+			data.Model = r.Spec.EffectiveAgentModel(step.Agent, r.Opts.ModelOverride)
+		} else {
+			data.Model = ""
+		}
+	}
+
+	var msg string
+	var err error
+	if name, ok := r.Spec.Commit.MessageAgent(); ok {
+		msg, err = r.agentCommitMessage(ctx, st, rs, name)
+	} else {
+		msg, err = renderCommitMessage(r.Spec.Commit.Message, data)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := checkCommitMessage(msg, r.Spec.Commit.EffectivePattern()); err != nil {
+		return "", err
+	}
+	return msg + "\n\n" + r.commitTrailers(data, r.coAuthors(ctx, rs)), nil
+}
+
+func renderCommitMessage(text string, data commitData) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		text = defaultCommitMessage
+	}
+	tmpl, err := template.New("commit").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse commit.message: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render commit.message: %w", err)
+	}
+	msg := strings.TrimSpace(buf.String())
+	if msg == "" {
+		return "", fmt.Errorf("commit.message rendered an empty message")
+	}
+	return msg, nil
+}
+
+// agentCommitMessage asks a read-only agent to describe the staged changes
+// in rs.
+func (r *Runner) agentCommitMessage(ctx context.Context, st *runState, rs *repoState, agentName string) (string, error) {
+	diff, err := gitutil.StagedDiff(ctx, rs.path)
+	if err != nil {
+		return "", err
+	}
+	p, err := r.renderPrompt(st, prompt.KindCommit, nil, prompt.Inputs{
+		Spec:          r.Spec,
+		Task:          r.Opts.Task,
+		CommitPrompt:  st.agentPrompts[agentName],
+		DiffOutput:    diff,
+		CommitPattern: r.Spec.Commit.EffectivePattern(),
+	})
+	if err != nil {
+		return "", err
+	}
+	out, err := r.Orchestrator.Run(ctx, p, orchestrator.RunConfig{
+		Model:         r.Spec.EffectiveAgentModel(agentName, r.Opts.ModelOverride),
+		Mode:          "ask",
+		WorkspacePath: st.workspacePath,
+	})
+	if err != nil {
+		return "", fmt.Errorf("commit message agent %q: %w", agentName, err)
+	}
+	msg := cleanAgentMessage(out.Stdout)
+	if msg == "" {
+		return "", fmt.Errorf("commit message agent %q returned an empty message", agentName)
+	}
+	return msg, nil
+}
+
+// cleanAgentMessage strips code fences and surrounding whitespace that
+// agents tend to wrap their answers in.
+func cleanAgentMessage(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "```") {
+		s = strings.TrimPrefix(s, "```")
+		if i := strings.Index(s, "\n"); i >= 0 {
+			s = s[i+1:]
+		} else {
+			s = ""
+		}
+		s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+	}
+	return strings.TrimSpace(s)
+}
+
+// checkCommitMessage validates the subject line of msg against pattern.
+func checkCommitMessage(msg, pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("commit.pattern: %w", err)
+	}
+	subject, _, _ := strings.Cut(msg, "\n")
+	if !re.MatchString(strings.TrimSpace(subject)) {
+		return fmt.Errorf("commit message %q does not match commit.pattern %q", subject, pattern)
+	}
+	return nil
+}
+
+func (r *Runner) commitTrailers(data commitData, coAuthors []string) string {
+	var lines []string
+	add := func(key, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	add("Devspec-Run", data.RunID)
+	add("Devspec-Step", data.Step)
+	add("Devspec-Model", data.Model)
+	add("Devspec-Spec-Hash", shortHash(r.Spec.SourceHash))
+
+	keys := make([]string, 0, len(r.Spec.Commit.Trailers))
+	for k := range r.Spec.Commit.Trailers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, r.Spec.Commit.Trailers[k])
+	}
+	for _, co := range coAuthors {
+		add("Co-authored-by", co)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+func newRunID(now func() time.Time) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
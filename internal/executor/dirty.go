@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// DirtyStrategy controls what happens when a repo has uncommitted changes
+// before a run starts.
+type DirtyStrategy string
+
+const (
+	// DirtyPrompt asks on a terminal whether to stash and aborts otherwise.
+	DirtyPrompt DirtyStrategy = ""
+	// DirtyAbort fails the run.
+	DirtyAbort DirtyStrategy = "abort"
+	// DirtyStash stashes the changes and restores them, together with the
+	// original branch, when the run ends.
+	DirtyStash DirtyStrategy = "stash"
+	// DirtyWorktree leaves the working tree alone and runs in a temporary
+	// git worktree instead.
+	DirtyWorktree DirtyStrategy = "worktree"
+	// DirtyInclude carries the changes onto the agent branch.
+	DirtyInclude DirtyStrategy = "include"
+)
+
+func ParseDirtyStrategy(s string) (DirtyStrategy, error) {
+	switch d := DirtyStrategy(strings.ToLower(strings.TrimSpace(s))); d {
+	case DirtyPrompt, DirtyAbort, DirtyStash, DirtyWorktree, DirtyInclude:
+		return d, nil
+	}
+	return "", fmt.Errorf("invalid --on-dirty value %q; allowed: abort, stash, worktree, include", s)
+}
+
+func (r *Runner) handleDirty(ctx context.Context, rs *repoState) error {
+	name := rs.spec.Name
+	dirty, _ := gitutil.DirtyFiles(ctx, rs.path)
+	dirty = strings.TrimSpace(dirty)
+
+	strategy := r.Opts.OnDirty
+	if strategy == DirtyPrompt {
+		if r.Opts.DryRun || !isInteractive() {
+			return fmt.Errorf("repo %q: git working tree is dirty:\n%s", name, dirty)
+		}
+		fmt.Printf("repo %q has uncommitted changes:\n%s\n", name, dirty)
+		fmt.Printf("Stash and continue? [y/N] ")
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		ans := strings.TrimSpace(strings.ToLower(scanner.Text()))
+		if ans != "y" && ans != "yes" {
+			return fmt.Errorf("repo %q: aborted due to dirty working tree", name)
+		}
+		strategy = DirtyStash
+	}
+
+	if strategy == DirtyInclude && r.Spec.Workspace.Atomic {
+		return fmt.Errorf("repo %q: --on-dirty=include cannot be combined with workspace.atomic, which discards uncommitted changes on rollback", name)
+	}
+	if strategy == DirtyAbort {
+		return fmt.Errorf("repo %q: git working tree is dirty (--on-dirty=abort):\n%s", name, dirty)
+	}
+	if r.Opts.DryRun {
+		fmt.Printf("dry-run: repo %q is dirty, would apply --on-dirty=%s\n", name, strategy)
+		return nil
+	}
+
+	switch strategy {
+	case DirtyStash:
+		ref, err := gitutil.Stash(ctx, rs.path, "devspec -- auto-stash before run")
+		if err != nil {
+			return fmt.Errorf("repo %q: stash failed: %w", name, err)
+		}
+		rs.stashRef = ref
+		fmt.Printf("stashed changes in %s\n", name)
+	case DirtyWorktree:
+		dir, err := os.MkdirTemp("", "devspec-worktree-*")
+		if err != nil {
+			return fmt.Errorf("repo %q: create worktree dir: %w", name, err)
+		}
+		if err := gitutil.WorktreeAdd(ctx, rs.path, dir, rs.spec.BaseBranch); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("repo %q: create worktree: %w", name, err)
+		}
+		rs.worktreeOf = rs.path
+		rs.path = dir
+		rs.touched = true
+		fmt.Printf("repo %q is dirty, running in worktree %s\n", name, dir)
+	case DirtyInclude:
+		rs.carry = true
+		fmt.Printf("repo %q is dirty, carrying uncommitted changes onto the agent branch\n", name)
+	}
+	return nil
+}
+
+// stashCarried stashes the changes --on-dirty=include carries, so the base
+// branch can be checked out and pulled on a clean tree.
+func stashCarried(ctx context.Context, rs *repoState) (string, error) {
+	if !rs.carry {
+		return "", nil
+	}
+	ref, err := gitutil.Stash(ctx, rs.path, "devspec -- changes carried onto the agent branch")
+	if err != nil {
+		return "", fmt.Errorf("repo %q: stash carried changes: %w", rs.spec.Name, err)
+	}
+	return ref, nil
+}
+
+// commitCarried applies the carried changes on the agent branch and commits
+// them on their own, so they are not counted as the run's changes.
+func commitCarried(ctx context.Context, rs *repoState, ref string) error {
+	name := rs.spec.Name
+	if err := gitutil.StashPop(ctx, rs.path, ref); err != nil {
+		return fmt.Errorf("repo %q: apply carried changes: %w", name, err)
+	}
+	if err := gitutil.AddAll(ctx, rs.path); err != nil {
+		return fmt.Errorf("repo %q: %w", name, err)
+	}
+	if err := gitutil.Commit(ctx, rs.path, "devspec: carry over uncommitted changes", gitutil.CommitOptions{}); err != nil {
+		return fmt.Errorf("repo %q: commit carried changes: %w", name, err)
+	}
+	head, err := gitutil.RevParse(ctx, rs.path, "HEAD")
+	if err != nil {
+		return fmt.Errorf("repo %q: %w", name, err)
+	}
+	rs.baseRef = head
+	fmt.Printf("committed uncommitted changes of %s on the agent branch\n", name)
+	return nil
+}
+
+// restoreRepos undoes the dirty-tree handling once a run is over, whether it
+// succeeded or not.
+func (r *Runner) restoreRepos(ctx context.Context, st *runState, failed bool) error {
+	var errs []error
+	for i := range st.repos {
+		rs := &st.repos[i]
+		switch {
+		case rs.worktreeOf != "":
+			if failed {
+				fmt.Printf("note: worktree for repo %q kept at %s for inspection\n", rs.spec.Name, rs.path)
+				continue
+			}
+			if r.Opts.KeepWorkspace {
+				continue
+			}
+			if err := gitutil.WorktreeRemove(ctx, rs.worktreeOf, rs.path); err != nil {
+				errs = append(errs, fmt.Errorf("repo %q: remove worktree: %w", rs.spec.Name, err))
+			}
+		case rs.stashRef != "":
+			if err := r.restoreStash(ctx, st, rs, failed); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restoreStash puts the user back on their branch with their stashed
+// changes. A run that succeeds without committing leaves its output in the
+// tree; it stays on the agent branch and the stash is kept for the user.
+func (r *Runner) restoreStash(ctx context.Context, st *runState, rs *repoState, failed bool) error {
+	name := rs.spec.Name
+	clean, err := gitutil.IsClean(ctx, rs.path)
+	if err != nil {
+		return fmt.Errorf("repo %q: %w", name, err)
+	}
+	if !clean && !failed {
+		fmt.Printf("note: repo %q has uncommitted changes from the run on %s; your stashed changes are kept as stash %s\n", name, st.branchName, rs.stashRef)
+		return nil
+	}
+	if !clean {
+		msg := fmt.Sprintf("devspec -- uncommitted changes left on %s", st.branchName)
+		if _, err := gitutil.Stash(ctx, rs.path, msg); err != nil {
+			return fmt.Errorf("repo %q: stash leftover changes: %w", name, err)
+		}
+		fmt.Printf("repo %q: uncommitted changes left by the run were stashed as %q\n", name, msg)
+	}
+	if rs.origBranch != "" {
+		if err := gitutil.Checkout(ctx, rs.path, rs.origBranch); err != nil {
+			return fmt.Errorf("repo %q: checkout original branch %s: %w", name, rs.origBranch, err)
+		}
+	}
+	if err := gitutil.StashPop(ctx, rs.path, rs.stashRef); err != nil {
+		if errors.Is(err, gitutil.ErrStashConflict) {
+			return fmt.Errorf("repo %q: restoring stashed changes on %s produced conflicts; resolve them and run 'git stash drop' in %s: %w", name, rs.origBranch, rs.path, err)
+		}
+		return fmt.Errorf("repo %q: restore stash: %w", name, err)
+	}
+	fmt.Printf("restored stashed changes in %s on branch %s\n", name, rs.origBranch)
+	return nil
+}
@@ -0,0 +1,299 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+func TestStashRestoredAfterRun(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		dir := t.TempDir()
+		git := func(args ...string) string {
+			t.Helper()
+			out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+			if err != nil {
+				t.Fatalf("git %v: %v\n%s", args, err, out)
+			}
+			return strings.TrimSpace(string(out))
+		}
+		write := func(name, body string) {
+			t.Helper()
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git("init", "-q", "-b", "main")
+		git("config", "user.name", "t")
+		git("config", "user.email", "t@example.com")
+		write("README.md", "# api\n")
+		git("add", ".")
+		git("commit", "-qm", "init")
+		write("README.md", "# api\nlocal notes\n")
+		write("scratch.txt", "todo\n")
+
+		ctx := context.Background()
+		r := Runner{
+			Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{{Name: "api", Path: dir}}}},
+			Opts: Options{OnDirty: DirtyStash},
+		}
+		st := &runState{branchName: "agent/add-column"}
+		if err := r.openRepos(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+		rs := &st.repos[0]
+		if err := r.handleDirty(ctx, rs); err != nil {
+			t.Fatal(err)
+		}
+		if status := git("status", "--porcelain"); rs.stashRef == "" || status != "" {
+			t.Fatalf("changes should be stashed, status:\n%s", status)
+		}
+
+		git("checkout", "-q", "-b", st.branchName)
+		write("main.go", "package main\n")
+		git("add", "main.go")
+		git("commit", "-qm", "add main")
+		if failed {
+			write("main.go", "package main\n\nfunc main() {}\n")
+		}
+
+		if err := r.restoreRepos(ctx, st, failed); err != nil {
+			t.Fatalf("failed=%v: %v", failed, err)
+		}
+		if branch := git("branch", "--show-current"); branch != "main" {
+			t.Errorf("failed=%v: on %s, want main", failed, branch)
+		}
+		if b, _ := os.ReadFile(filepath.Join(dir, "README.md")); string(b) != "# api\nlocal notes\n" {
+			t.Errorf("failed=%v: README.md not restored: %q", failed, b)
+		}
+		if b, _ := os.ReadFile(filepath.Join(dir, "scratch.txt")); string(b) != "todo\n" {
+			t.Errorf("failed=%v: untracked file not restored: %q", failed, b)
+		}
+		stashes := git("stash", "list", "--format=%H %s")
+		if strings.Contains(stashes, rs.stashRef) {
+			t.Errorf("failed=%v: stash should be dropped:\n%s", failed, stashes)
+		}
+		if left := strings.Contains(stashes, "uncommitted changes left on agent/add-column"); left != failed {
+			t.Errorf("failed=%v: leftover changes stash: %q", failed, stashes)
+		}
+	}
+}
+
+func TestStashKeptWhenRunLeavesUncommittedOutput(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "main")
+	git("config", "user.name", "t")
+	git("config", "user.email", "t@example.com")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("local notes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{{Name: "api", Path: dir}}}},
+		Opts: Options{OnDirty: DirtyStash},
+	}
+	st := &runState{branchName: "agent/add-column"}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	rs := &st.repos[0]
+	if err := r.handleDirty(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+	git("checkout", "-q", "-b", st.branchName)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.restoreRepos(ctx, st, false); err != nil {
+		t.Fatal(err)
+	}
+	if branch := git("branch", "--show-current"); branch != st.branchName {
+		t.Errorf("on %s, want %s", branch, st.branchName)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+		t.Errorf("the run's output should stay in the tree: %v", err)
+	}
+	if stashes := git("stash", "list", "--format=%H"); stashes != rs.stashRef {
+		t.Errorf("only the user's stash should be left, got:\n%s", stashes)
+	}
+}
+
+func TestStashPopConflictKeepsStash(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	git("config", "user.name", "t")
+	git("config", "user.email", "t@example.com")
+	write("# api\n")
+	git("add", ".")
+	git("commit", "-qm", "init")
+	write("# api\nlocal notes\n")
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{{Name: "api", Path: dir}}}},
+		Opts: Options{OnDirty: DirtyStash},
+	}
+	st := &runState{}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	rs := &st.repos[0]
+	if err := r.handleDirty(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+	write("# api\nupstream notes\n")
+	git("commit", "-qam", "edit readme")
+
+	err := r.restoreRepos(ctx, st, false)
+	if err == nil || !strings.Contains(err.Error(), "produced conflicts") || !strings.Contains(err.Error(), "kept as stash@{0}") {
+		t.Fatalf("expected a conflict naming the kept stash, got %v", err)
+	}
+	if stashes := git("stash", "list", "--format=%H"); !strings.Contains(stashes, rs.stashRef) {
+		t.Fatalf("stash %s should be kept, got:\n%s", rs.stashRef, stashes)
+	}
+}
+
+func TestWorktreeKeptOnlyAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	git("-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("local notes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{{Name: "api", Path: dir, BaseBranch: "main"}}}},
+		Opts: Options{OnDirty: DirtyWorktree},
+	}
+	st := &runState{}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	rs := &st.repos[0]
+	if err := r.handleDirty(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+	worktree := rs.path
+	t.Cleanup(func() { os.RemoveAll(worktree) })
+	if _, err := os.Stat(filepath.Join(worktree, "README.md")); rs.worktreeOf != dir || !os.IsNotExist(err) {
+		t.Fatalf("expected a clean worktree of %s, got %s", dir, worktree)
+	}
+
+	if err := r.restoreRepos(ctx, st, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(worktree); err != nil {
+		t.Fatalf("worktree should be kept after a failed run: %v", err)
+	}
+	if err := r.restoreRepos(ctx, st, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(worktree); !os.IsNotExist(err) {
+		t.Fatalf("worktree should be removed: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "README.md")); string(b) != "local notes\n" {
+		t.Fatalf("original tree changed: %q", b)
+	}
+}
+
+func TestIncludeCommitsCarriedChanges(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_NAME", "t")
+	t.Setenv("GIT_AUTHOR_EMAIL", "t@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "t")
+	t.Setenv("GIT_COMMITTER_EMAIL", "t@example.com")
+	dir, origin, other := t.TempDir(), t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(dir, name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(origin, "init", "-q", "--bare", "-b", "main")
+	git(dir, "clone", "-q", origin, ".")
+	git(dir, "commit", "-q", "--allow-empty", "-m", "init")
+	git(dir, "push", "-q", "origin", "main")
+	git(other, "clone", "-q", origin, ".")
+	write(other, "upstream.go", "package api\n")
+	git(other, "add", ".")
+	git(other, "commit", "-qm", "upstream change")
+	git(other, "push", "-q", "origin", "main")
+	write(dir, "README.md", "local notes\n")
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{
+			{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"},
+		}}},
+		Opts: Options{OnDirty: DirtyInclude, Branch: "agent/add-column"},
+	}
+	st := &runState{}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	rs := &st.repos[0]
+	if err := r.handleDirty(ctx, rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setupWorkspace(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if subject := git(dir, "log", "-1", "--format=%s"); subject != "devspec: carry over uncommitted changes" {
+		t.Errorf("HEAD: %q", subject)
+	}
+	if got := git(dir, "show", "--name-only", "--format=", "HEAD~1"); got != "upstream.go" {
+		t.Errorf("the base branch should be pulled first, HEAD~1 touches %q", got)
+	}
+	files, err := repoChangedFiles(ctx, *rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 || git(dir, "status", "--porcelain") != "" || git(dir, "stash", "list") != "" {
+		t.Errorf("carried changes should not count as the run's: %v", files)
+	}
+}
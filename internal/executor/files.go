@@ -0,0 +1,260 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+	"github.com/threatlevelmidnight10/devspec/internal/repocontext"
+)
+
+// loadContextFiles inlines the files matching context.files in every repo.
+// Files are listed with git, so ignored files are never included.
+func (r *Runner) loadContextFiles(ctx context.Context, st *runState) error {
+	for _, pattern := range r.Spec.Context.Files {
+		matched := false
+		for _, rs := range st.repos {
+			files, err := gitutil.ListFiles(ctx, rs.path, ":(glob)"+strings.TrimPrefix(pattern, "/"))
+			if err != nil {
+				return fmt.Errorf("repo %q context.files: %w", rs.spec.Name, err)
+			}
+			for _, f := range files {
+				matched = true
+				if err := r.addContextFile(st, rs, f); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched {
+			st.logf("warning: context.files pattern %q matched no files\n", pattern)
+		}
+	}
+	return nil
+}
+
+// addPlanFiles inlines the files listed in the Files section of the plan
+// that exist and are not ignored.
+func (r *Runner) addPlanFiles(ctx context.Context, st *runState) error {
+	targets, err := planTargets(ctx, st)
+	if err != nil {
+		return err
+	}
+	before := len(st.files)
+	for _, t := range targets {
+		if err := r.addContextFile(st, t.repo, t.rel); err != nil {
+			return err
+		}
+	}
+	if added := st.files[before:]; len(added) > 0 {
+		names := make([]string, 0, len(added))
+		for _, f := range added {
+			names = append(names, f.Name)
+		}
+		st.logf("  inlining files named in the plan: %s\n", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// planTarget is a file named in the plan, found in one of the repos.
+type planTarget struct {
+	repo repoState
+	rel  string
+}
+
+// planTargets finds the files listed in the Files section of the plan that
+// exist in the repos and are not ignored. A directory in the plan lists
+// everything below it.
+func planTargets(ctx context.Context, st *runState) ([]planTarget, error) {
+	refs := planFiles(st.planOutput)
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	var targets []planTarget
+	for _, rs := range st.repos {
+		var specs []string
+		for _, ref := range refs {
+			if rel, ok := planFileIn(rs, ref, len(st.repos) > 1); ok {
+				specs = append(specs, ":(literal)"+rel)
+			}
+		}
+		if len(specs) == 0 {
+			continue
+		}
+		files, err := gitutil.ListFiles(ctx, rs.path, specs...)
+		if err != nil {
+			return nil, fmt.Errorf("repo %q plan files: %w", rs.spec.Name, err)
+		}
+		for _, f := range files {
+			targets = append(targets, planTarget{repo: rs, rel: f})
+		}
+	}
+	return targets, nil
+}
+
+// plannedPaths returns the paths the plan names as they are written, in
+// the repo they go to, whether or not they exist yet. In multi-repo
+// workspaces only paths that name their repo are returned.
+func plannedPaths(st *runState) []planTarget {
+	multi := len(st.repos) > 1
+	var out []planTarget
+	for _, ref := range planFiles(st.planOutput) {
+		for _, rs := range st.repos {
+			rel, ok := planFileIn(rs, ref, multi)
+			if ok && (!multi || filepath.IsAbs(ref) || rel != strings.TrimPrefix(filepath.ToSlash(ref), "./")) {
+				out = append(out, planTarget{repo: rs, rel: rel})
+			}
+		}
+	}
+	return out
+}
+
+// contextFile is a file inlined into prompts. It remembers where it was
+// read from, since steps may change it.
+type contextFile struct {
+	repocontext.File
+	path string
+	rel  string
+}
+
+// addContextFile reads rel, relative to the root of rs, into st.files
+// unless it is already there. Binary files are skipped and files over
+// context.max_file_bytes are cut with a marker.
+func (r *Runner) addContextFile(st *runState, rs repoState, rel string) error {
+	name := rs.spec.Name + ": " + rel
+	for _, f := range st.files {
+		if f.Name == name {
+			return nil
+		}
+	}
+	path := filepath.Join(rs.path, filepath.FromSlash(rel))
+	body, binary, err := r.readContextFile(path, name, rel)
+	if err != nil {
+		return err
+	}
+	if binary {
+		st.logf("warning: skipping binary context file %s\n", name)
+		return nil
+	}
+	if body == "" {
+		return nil // tracked but deleted
+	}
+	st.files = append(st.files, contextFile{File: repocontext.File{Name: name, Body: body}, path: path, rel: rel})
+	return nil
+}
+
+// contextBodies reads the files in st.files again, so each step sees what
+// earlier steps made of them. Files deleted since are left out.
+func (r *Runner) contextBodies(st *runState) ([]string, error) {
+	out := make([]string, 0, len(st.files))
+	for i, f := range st.files {
+		body, binary, err := r.readContextFile(f.path, f.Name, f.rel)
+		if err != nil {
+			return nil, err
+		}
+		if binary || body == "" {
+			continue
+		}
+		st.files[i].Body = body
+		out = append(out, body)
+	}
+	return out, nil
+}
+
+// readContextFile returns the prompt body of the file at path, or "" if it
+// does not exist.
+func (r *Runner) readContextFile(path, name, rel string) (body string, binary bool, err error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("read context file: %w", err)
+	}
+	if bytes.IndexByte(b[:min(len(b), 8000)], 0) >= 0 {
+		return "", true, nil
+	}
+	return fmt.Sprintf("==> %s\n%s", name, limitFile(string(b), rel, r.Spec.Context.MaxFileBytes)), false, nil
+}
+
+// limitFile cuts content to at most limit bytes, at a line boundary where
+// possible, and notes how much was cut.
+func limitFile(content, name string, limit int) string {
+	content = strings.TrimRight(content, "\n")
+	if limit <= 0 || len(content) <= limit {
+		return content
+	}
+	cut := content[:limit]
+	if i := strings.LastIndexByte(cut, '\n'); i > 0 {
+		cut = cut[:i]
+	}
+	return fmt.Sprintf("%s\n[... devspec: %d more bytes of %s truncated ...]", cut, len(content)-len(cut), name)
+}
+
+func fileBodies(files []repocontext.File) []string {
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		out = append(out, f.Body)
+	}
+	return out
+}
+
+var (
+	planHeading = regexp.MustCompile(`^\s*(?:#+\s*([A-Za-z][\w ]*?)\s*:?|\*\*([A-Za-z][\w ]*?):?\*\*:?|([A-Za-z][\w ]*?):)\s*$`)
+	planBullet  = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+`)
+	planPath    = regexp.MustCompile("`([^`]+)`|([^\\s`]+)")
+)
+
+// planFiles returns the paths listed under the "Files" heading of a plan,
+// e.g. "Files:", "## Files" or "**Files**", one per line, up to the next
+// heading. Each line's first path-like word, or backquoted text, is taken.
+func planFiles(plan string) []string {
+	var out []string
+	in := false
+	for _, line := range strings.Split(plan, "\n") {
+		if m := planHeading.FindStringSubmatch(line); m != nil {
+			in = strings.EqualFold(m[1]+m[2]+m[3], "files")
+			continue
+		}
+		if !in {
+			continue
+		}
+		line = planBullet.ReplaceAllString(line, "")
+		for _, m := range planPath.FindAllStringSubmatch(line, -1) {
+			p := m[1]
+			if p == "" {
+				p = m[2]
+			}
+			p = strings.TrimRight(p, ":,;")
+			if strings.ContainsAny(p, "./") && !strings.Contains(p, "://") {
+				out = append(out, p)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// planFileIn resolves a path from the plan to a path relative to the root
+// of rs. Absolute paths must be inside the repo; in multi-repo workspaces a
+// leading repo name is stripped.
+func planFileIn(rs repoState, ref string, multi bool) (string, bool) {
+	if filepath.IsAbs(ref) {
+		rel, err := filepath.Rel(rs.path, ref)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+		return filepath.ToSlash(rel), true
+	}
+	ref = strings.TrimPrefix(filepath.ToSlash(ref), "./")
+	if multi {
+		if rest, ok := strings.CutPrefix(ref, rs.spec.Name+"/"); ok {
+			return rest, true
+		}
+	}
+	return ref, true
+}
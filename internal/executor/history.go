@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// maxBlameCommits is how many commits a blame summary names.
+const maxBlameCommits = 3
+
+// gitLogSince returns the date of the oldest commit context.git_log lets
+// agents see, or the zero time if there is no limit.
+func (r *Runner) gitLogSince() time.Time {
+	days := r.Spec.Context.GitLog.MaxAgeDays
+	if days == 0 {
+		return time.Time{}
+	}
+	return r.Now().AddDate(0, 0, -days)
+}
+
+// loadGitLog collects the subjects of the recent commits of every repo.
+func (r *Runner) loadGitLog(ctx context.Context, st *runState) error {
+	n := r.Spec.Context.GitLog.Commits
+	if n == 0 {
+		return nil
+	}
+	var logs []string
+	for _, rs := range st.repos {
+		lines, err := gitutil.Log(ctx, rs.path, n, r.gitLogSince())
+		if err != nil {
+			return fmt.Errorf("repo %q git log: %w", rs.spec.Name, err)
+		}
+		if len(lines) > 0 {
+			logs = append(logs, fmt.Sprintf("==> %s:\n%s", rs.spec.Name, strings.Join(lines, "\n")))
+		}
+	}
+	st.gitLog = strings.Join(logs, "\n\n")
+	return nil
+}
+
+// addFileHistory collects the log and a blame summary of each file the
+// plan names.
+func (r *Runner) addFileHistory(ctx context.Context, st *runState) error {
+	targets, err := planTargets(ctx, st)
+	if err != nil {
+		return err
+	}
+	since := r.gitLogSince()
+	var blocks []string
+	for _, t := range targets {
+		log, err := gitutil.Log(ctx, t.repo.path, r.Spec.Context.GitLog.FileCommits, since, t.rel)
+		if err != nil {
+			return fmt.Errorf("repo %q git log: %w", t.repo.spec.Name, err)
+		}
+		if len(log) == 0 {
+			// Nothing recent; blame fails for files never committed.
+			if all, err := gitutil.Log(ctx, t.repo.path, 1, time.Time{}, t.rel); err != nil || len(all) == 0 {
+				continue
+			}
+		}
+		blame, err := gitutil.Blame(ctx, t.repo.path, t.rel, since)
+		if err != nil {
+			return fmt.Errorf("repo %q blame: %w", t.repo.spec.Name, err)
+		}
+		blocks = append(blocks, fmt.Sprintf("==> %s: %s\n%s", t.repo.spec.Name, t.rel, r.historySummary(log, blame)))
+	}
+	st.fileHistory = strings.Join(blocks, "\n\n")
+	return nil
+}
+
+func (r *Runner) historySummary(log []string, blame []gitutil.BlameCommit) string {
+	var b strings.Builder
+	b.WriteString("log:\n")
+	if len(log) == 0 {
+		b.WriteString("  (no recent commits)\n")
+	}
+	for _, l := range log {
+		b.WriteString("  " + l + "\n")
+	}
+	total := 0
+	for _, c := range blame {
+		total += c.Lines
+	}
+	fmt.Fprintf(&b, "blame (%d lines):", total)
+	named, older := 0, 0
+	for _, c := range blame {
+		if c.Boundary {
+			older += c.Lines
+			continue
+		}
+		if named == maxBlameCommits {
+			continue
+		}
+		named++
+		fmt.Fprintf(&b, "\n  %d lines from %s %s %s (%s)", c.Lines, c.Hash, c.Time.Format(time.DateOnly), c.Summary, c.Author)
+	}
+	if older > 0 {
+		fmt.Fprintf(&b, "\n  %d lines unchanged in the last %d days", older, r.Spec.Context.GitLog.MaxAgeDays)
+	}
+	return b.String()
+}
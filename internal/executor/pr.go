@@ -0,0 +1,244 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/codeowners"
+	"github.com/threatlevelmidnight10/devspec/internal/forge"
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// pullRequest is a change request opened or updated by a run.
+type pullRequest struct {
+	Repo string
+	URL  string
+
+	body  string
+	forge forge.Forge
+	cr    *forge.ChangeRequest
+}
+
+// openPR opens a change request for the agent branch of rs, or updates the
+// one already open for it.
+func (r *Runner) openPR(ctx context.Context, st *runState, rs *repoState, report prData) (pullRequest, error) {
+	pr := pullRequest{Repo: rs.spec.Name}
+	body, err := r.renderPRBody(report, rs.spec.Name)
+	if err != nil {
+		return pr, err
+	}
+	pr.body = body
+	meta := r.Spec.Output.PR
+	labels := mergeLabels(meta.Labels, report.Labels)
+	reviewers, teams, err := r.prReviewers(rs, report)
+	if err != nil {
+		return pr, err
+	}
+
+	fg, err := r.forgeFor(ctx, rs)
+	if err != nil {
+		return pr, err
+	}
+	pr.forge = fg
+	existing, err := fg.FindOpen(ctx, st.branchName)
+	if err != nil {
+		return pr, err
+	}
+	if existing != nil {
+		pr.cr, pr.URL = existing, existing.URL
+		if err := updatePR(ctx, fg, existing, body, iterationComment(report, rs.spec.Name), labels, reviewers, teams); err != nil {
+			return pr, fmt.Errorf("update %s: %w", existing.URL, err)
+		}
+		fmt.Printf("pull request updated: %s\n", existing.URL)
+		return pr, nil
+	}
+	title, err := r.renderPRTitle(report, rs.spec.Name)
+	if err != nil {
+		return pr, err
+	}
+	cr, err := fg.Create(ctx, forge.CreateOptions{
+		Base:          rs.spec.BaseBranch,
+		Head:          st.branchName,
+		Title:         title,
+		Body:          body,
+		Draft:         meta.Draft,
+		Labels:        labels,
+		Reviewers:     reviewers,
+		TeamReviewers: teams,
+		Assignees:     meta.Assignees,
+		Milestone:     meta.Milestone,
+	})
+	if cr == nil {
+		return pr, err
+	}
+	pr.cr, pr.URL = cr, cr.URL
+	fmt.Printf("pull request created: %s\n", pr.URL)
+	if err != nil {
+		fmt.Printf("warning: %s: request reviewers: %v\n", pr.URL, err)
+	}
+	return pr, nil
+}
+
+// updatePR brings an open change request up to date with a new iteration
+// on its branch: it replaces the body, comments on what changed, makes sure
+// the labels are set and asks the reviewers to look again. Reviewers that
+// cannot be requested only get a warning.
+func updatePR(ctx context.Context, fg forge.Forge, cr *forge.ChangeRequest, body, comment string, labels, reviewers, teams []string) error {
+	if err := fg.UpdateBody(ctx, cr, body); err != nil {
+		return err
+	}
+	if err := fg.Comment(ctx, cr, comment); err != nil {
+		return err
+	}
+	if err := fg.AddLabels(ctx, cr, labels); err != nil {
+		return err
+	}
+	if err := fg.RequestReviewers(ctx, cr, reviewers, teams); err != nil {
+		fmt.Printf("warning: %s: request reviewers: %v\n", cr.URL, err)
+	}
+	return nil
+}
+
+// prReviewers returns the users and the "org/team" teams to request
+// reviews from: output.pr.reviewers plus, with output.pr.codeowners, the
+// CODEOWNERS owners of the files changed in rs. Owners given as email
+// addresses are skipped; forges want user or team names.
+func (r *Runner) prReviewers(rs *repoState, report prData) (users, teams []string, err error) {
+	add := func(name string) {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if name == "" || slices.Contains(users, name) || slices.Contains(teams, name) {
+			return
+		}
+		if strings.Contains(name, "/") {
+			teams = append(teams, name)
+		} else {
+			users = append(users, name)
+		}
+	}
+	for _, name := range r.Spec.Output.PR.Reviewers {
+		add(name)
+	}
+	if !r.Spec.Output.PR.CodeOwners {
+		return users, teams, nil
+	}
+	owners, err := codeowners.Load(rs.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("repo %q: %w", rs.spec.Name, err)
+	}
+	if owners == nil {
+		return users, teams, nil
+	}
+	var files []string
+	for _, rp := range report.Repos {
+		if rp.Name == rs.spec.Name {
+			files = rp.Files
+		}
+	}
+	for _, owner := range owners.OwnersOf(files) {
+		if strings.HasPrefix(owner, "@") {
+			add(owner)
+		}
+	}
+	return users, teams, nil
+}
+
+// forgeFor returns the forge change requests for rs are opened on: the
+// repo's forge setting, else output.forge, else whatever the remote URL
+// points at.
+func (r *Runner) forgeFor(ctx context.Context, rs *repoState) (forge.Forge, error) {
+	repo := forge.Repo{Dir: rs.path}
+	rawURL, err := gitutil.RemoteURL(ctx, rs.path, rs.spec.Remote)
+	if err != nil {
+		return nil, err
+	}
+	if upstream, err := gitutil.ParseRemoteURL(rawURL); err == nil {
+		repo.Target = upstream
+	}
+	if rs.spec.PushRemote != rs.spec.Remote {
+		pushURL, err := gitutil.RemoteURL(ctx, rs.path, rs.spec.PushRemote)
+		if err != nil {
+			return nil, err
+		}
+		fork, err := gitutil.ParseRemoteURL(pushURL)
+		if err != nil {
+			return nil, fmt.Errorf("push_remote %q: %w", rs.spec.PushRemote, err)
+		}
+		repo.Fork = fork
+	}
+
+	kind := rs.spec.Forge
+	if kind == "" {
+		kind = r.Spec.Output.Forge
+	}
+	if kind == "" {
+		kind = forge.Detect(repo.Target.Host)
+	}
+	return forge.New(kind, repo, forge.Config{
+		URL:      r.Spec.Output.Gitea.URL,
+		TokenEnv: r.Spec.Output.Gitea.TokenEnv,
+	})
+}
+
+// linkPRs adds a section listing the sibling pull requests, and the merge
+// order if repos declare dependencies, to every pull request of a
+// multi-repo run.
+func (r *Runner) linkPRs(ctx context.Context, st *runState, prs []pullRequest) error {
+	if len(prs) < 2 {
+		return nil
+	}
+	order, err := r.Spec.Workspace.MergeOrder()
+	if err != nil {
+		return err
+	}
+	for _, pr := range prs {
+		linked := strings.TrimSpace(pr.body) + "\n\n" + relatedPRsSection(pr, prs, order, st.runID)
+		if err := pr.forge.UpdateBody(ctx, pr.cr, linked); err != nil {
+			return fmt.Errorf("link %s: %w", pr.URL, err)
+		}
+	}
+	fmt.Printf("linked %d pull requests\n", len(prs))
+	return nil
+}
+
+func relatedPRsSection(self pullRequest, prs []pullRequest, order []string, runID string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Related pull requests\n\nPart of devspec run `%s` across %d repositories:\n\n", runID, len(prs))
+	byRepo := make(map[string]pullRequest, len(prs))
+	for _, pr := range prs {
+		byRepo[pr.Repo] = pr
+		fmt.Fprintf(&b, "- **%s**: %s%s\n", pr.Repo, pr.URL, thisPR(pr, self))
+	}
+	if len(order) > 0 {
+		b.WriteString("\nMerge order:\n\n")
+		n := 0
+		for _, name := range order {
+			pr, ok := byRepo[name]
+			if !ok {
+				continue
+			}
+			n++
+			fmt.Fprintf(&b, "%d. **%s**: %s%s\n", n, name, pr.URL, thisPR(pr, self))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func thisPR(pr, self pullRequest) string {
+	if pr.Repo == self.Repo {
+		return " (this pull request)"
+	}
+	return ""
+}
+
+// mergeLabels appends the labels of b missing from a, ignoring case.
+func mergeLabels(a, b []string) []string {
+	out := slices.Clone(a)
+	for _, l := range b {
+		if !slices.ContainsFunc(out, func(o string) bool { return strings.EqualFold(o, l) }) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
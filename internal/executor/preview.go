@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/threatlevelmidnight10/devspec/internal/prompt"
+	"github.com/threatlevelmidnight10/devspec/internal/repocontext"
+)
+
+// PreviewOptions configures RenderPrompts. PlanOutput and Diff stand in for
+// what the plan step would write and what the review step would see.
+type PreviewOptions struct {
+	Step       string // only this step; every agent step if empty
+	PlanOutput string
+	Diff       string
+}
+
+// RenderPrompts writes the exact prompt each agent step of the spec would
+// get, with token estimates per section. No agent runs and git state is
+// left alone: the repos are read where they stand. Notes such as what was
+// trimmed to fit context.max_tokens go to stderr, so w only gets prompts.
+func (r *Runner) RenderPrompts(ctx context.Context, w io.Writer, opts PreviewOptions) error {
+	if r.Spec == nil {
+		return errors.New("spec is required")
+	}
+	if strings.TrimSpace(r.Opts.Task) == "" {
+		return errors.New("task is required")
+	}
+	if r.Now == nil {
+		r.Now = time.Now
+	}
+	if opts.Step != "" && !r.hasAgentStep(opts.Step) {
+		return fmt.Errorf("no agent step named %q", opts.Step)
+	}
+
+	st := &runState{agentPrompts: map[string]string{}, runID: newRunID(r.Now), log: os.Stderr}
+	if err := r.openRepos(ctx, st); err != nil {
+		return err
+	}
+	if err := r.loadContent(st); err != nil {
+		return err
+	}
+	if err := r.gatherContext(ctx, st); err != nil {
+		return err
+	}
+
+	for i, step := range r.Spec.Steps {
+		if strings.TrimSpace(step.Run) != "" {
+			if opts.Step == "" {
+				fmt.Fprintf(w, "==> [%d/%d] %s (shell: %s)\n\n", i+1, len(r.Spec.Steps), step.Name, step.Run)
+			}
+			continue
+		}
+		kind := stepKind(step)
+		if opts.Step == "" || opts.Step == step.Name {
+			fmt.Fprintf(w, "==> [%d/%d] %s (agent: %s, prompt: %s)\n", i+1, len(r.Spec.Steps), step.Name, step.Agent, kind)
+			in, err := r.stepInputs(ctx, st, step)
+			if err != nil {
+				return err
+			}
+			if kind == prompt.KindReview && opts.Diff != "" {
+				in.DiffOutput = opts.Diff
+			}
+			in = r.promptInputs(st, kind, in)
+			p, err := prompt.Render(kind, r.promptTemplate(st, kind, &step), in)
+			if err != nil {
+				return fmt.Errorf("step %s: %w", step.Name, err)
+			}
+			fmt.Fprintf(w, "%s\n\n%s\n\n", sectionTokens(in, p), p)
+		}
+		if kind == prompt.KindPlan {
+			st.planOutput = opts.PlanOutput
+			if st.planOutput == "" {
+				st.planOutput = fmt.Sprintf("(output of plan step %q; pass --plan-output to preview it)", step.Name)
+				continue
+			}
+			if err := r.addPlanContext(ctx, st); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Runner) hasAgentStep(name string) bool {
+	for _, step := range r.Spec.Steps {
+		if step.Name == name && strings.TrimSpace(step.Agent) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sectionTokens estimates the tokens of the prompt p and of each non-empty
+// section of in.
+func sectionTokens(in prompt.Inputs, p string) string {
+	sections := []struct {
+		name string
+		text string
+	}{
+		{"system prompt", in.PlannerPrompt + in.ImplPrompt},
+		{"task", in.Task + strings.Join(in.Acceptance, "\n")},
+		{"feedback", in.Feedback},
+		{"skills", strings.Join(in.Skills, "\n")},
+		{"repo tree", in.RepoTree},
+		{"symbols", in.Symbols},
+		{"files", strings.Join(in.Files, "\n")},
+		{"git log", in.GitLog},
+		{"file history", in.FileHistory},
+		{"git diff", in.GitDiff},
+		{"plan output", in.PlanOutput},
+		{"diff", in.DiffOutput},
+	}
+	parts := []string{}
+	for _, s := range sections {
+		if strings.TrimSpace(s.text) != "" {
+			parts = append(parts, fmt.Sprintf("%s %d", s.name, repocontext.EstimateTokens(s.text)))
+		}
+	}
+	return fmt.Sprintf("~%d tokens (%s)", repocontext.EstimateTokens(p), strings.Join(parts, ", "))
+}
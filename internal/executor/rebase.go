@@ -0,0 +1,198 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+	"github.com/threatlevelmidnight10/devspec/internal/orchestrator"
+	"github.com/threatlevelmidnight10/devspec/internal/prompt"
+)
+
+// rebaseRepos rebases the agent branch of every repo onto the latest base
+// branch and validates the result like any other mutating step.
+func (r *Runner) rebaseRepos(ctx context.Context, st *runState) error {
+	if !r.Spec.Workspace.Rebase.Enabled || r.Opts.DryRun {
+		return nil
+	}
+	for i := range st.repos {
+		if err := r.rebaseRepo(ctx, st, &st.repos[i]); err != nil {
+			return fmt.Errorf("repo %q rebase: %w", st.repos[i].spec.Name, err)
+		}
+	}
+	return r.validateMutation(ctx, st, false)
+}
+
+// rebaseRepo rebases rs onto its base branch on the remote. Repos without
+// the remote, or whose base branch only exists locally, are left alone, as
+// in setupWorkspace. Conflicts resolved by an agent must keep the run within
+// its constraints, or the branch is put back as it was.
+func (r *Runner) rebaseRepo(ctx context.Context, st *runState, rs *repoState) error {
+	remote := rs.spec.Remote
+	base := rs.spec.BaseBranch
+	if ok, err := gitutil.HasRemote(ctx, rs.path, remote); err != nil || !ok {
+		return err
+	}
+	err := gitutil.Fetch(ctx, rs.path, remote, base)
+	if errors.Is(err, gitutil.ErrRemoteRefNotFound) {
+		fmt.Printf("  %s is not on %s, not rebasing %s\n", base, remote, rs.spec.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	upstream := remote + "/" + base
+	upToDate, err := gitutil.IsAncestor(ctx, rs.path, upstream, "HEAD")
+	if err != nil {
+		return err
+	}
+	if upToDate {
+		return nil
+	}
+
+	// The run's own commits are the last ones on the branch. Count them so
+	// baseRef can follow the rebase.
+	own, err := gitutil.CountCommits(ctx, rs.path, rs.baseRef, "HEAD")
+	if err != nil {
+		return err
+	}
+
+	head, err := gitutil.RevParse(ctx, rs.path, "HEAD")
+	if err != nil {
+		return err
+	}
+	baseRef := rs.baseRef
+
+	opts := r.commitOptions()
+	resolved := false
+	err = gitutil.Rebase(ctx, rs.path, upstream, opts)
+	for round := 1; errors.Is(err, gitutil.ErrRebaseConflict); round++ {
+		agentName := strings.TrimSpace(r.Spec.Workspace.Rebase.Agent)
+		if agentName == "" || round > r.Spec.Workspace.Rebase.MaxRounds {
+			_ = gitutil.RebaseAbort(ctx, rs.path)
+			return fmt.Errorf("conflicts with %s could not be resolved (%w)", upstream, err)
+		}
+		fmt.Printf("  conflicts rebasing %s onto %s, running %s (round %d)\n", rs.spec.Name, upstream, agentName, round)
+		if rerr := r.resolveConflicts(ctx, st, rs, agentName); rerr != nil {
+			_ = gitutil.RebaseAbort(ctx, rs.path)
+			return rerr
+		}
+		resolved = true
+		err = gitutil.RebaseContinue(ctx, rs.path, opts)
+	}
+	if err != nil {
+		_ = gitutil.RebaseAbort(ctx, rs.path)
+		return err
+	}
+
+	// Measure constraints against the rebased commit below the run's own
+	// commits, so neither upstream changes nor earlier iterations of a
+	// continued branch count towards the agent's diff. Commits the rebase
+	// dropped as already upstream leave fewer on the branch.
+	mergeBase, err := gitutil.MergeBase(ctx, rs.path, "HEAD", upstream)
+	if err != nil {
+		return err
+	}
+	onBranch, err := gitutil.CountCommits(ctx, rs.path, mergeBase, "HEAD")
+	if err != nil {
+		return err
+	}
+	rs.baseRef = mergeBase
+	if own < onBranch {
+		if rs.baseRef, err = gitutil.RevParse(ctx, rs.path, fmt.Sprintf("HEAD~%d", own)); err != nil {
+			return err
+		}
+	}
+	if resolved {
+		if err := r.validateMutation(ctx, st, false); err != nil {
+			rs.baseRef = baseRef
+			if rerr := gitutil.ResetHard(ctx, rs.path, head); rerr != nil {
+				return errors.Join(err, rerr)
+			}
+			return fmt.Errorf("conflict resolution: %w", err)
+		}
+	}
+	rs.rebased = true
+	fmt.Printf("rebased %s onto %s\n", rs.spec.Name, upstream)
+	return nil
+}
+
+func (r *Runner) resolveConflicts(ctx context.Context, st *runState, rs *repoState, agentName string) error {
+	if err := r.bumpIteration(st); err != nil {
+		return err
+	}
+	files, err := gitutil.ConflictedFiles(ctx, rs.path)
+	if err != nil {
+		return err
+	}
+	hunks, err := gitutil.Diff(ctx, rs.path)
+	if err != nil {
+		return err
+	}
+	absFiles := make([]string, len(files))
+	for i, f := range files {
+		absFiles[i] = filepath.Join(rs.path, f)
+	}
+
+	skills, err := r.stepSkills(ctx, st, "", prompt.KindResolve)
+	if err != nil {
+		return err
+	}
+	p, err := r.renderPrompt(st, prompt.KindResolve, nil, prompt.Inputs{
+		Spec:          r.Spec,
+		Task:          r.Opts.Task,
+		ResolvePrompt: st.agentPrompts[agentName],
+		Skills:        skills,
+		ConflictFiles: absFiles,
+		ConflictDiff:  hunks,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.Orchestrator.Run(ctx, p, orchestrator.RunConfig{
+		Model:         r.Spec.EffectiveAgentModel(agentName, r.Opts.ModelOverride),
+		WorkspacePath: st.workspacePath,
+	})
+	if err != nil {
+		return fmt.Errorf("conflict resolution agent %q: %w", agentName, err)
+	}
+
+	for _, f := range absFiles {
+		marked, err := hasConflictMarkers(f)
+		if err != nil {
+			return err
+		}
+		if marked {
+			return fmt.Errorf("conflict resolution agent %q left conflict markers in %s", agentName, f)
+		}
+	}
+	return gitutil.AddAll(ctx, rs.path)
+}
+
+func hasConflictMarkers(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Resolved by deleting the file.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		// A bare "=======" is also a markdown heading underline, so only
+		// the opening and closing markers are checked.
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true, nil
+		}
+	}
+	return false, sc.Err()
+}
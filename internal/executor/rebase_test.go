@@ -0,0 +1,286 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/threatlevelmidnight10/devspec/internal/orchestrator"
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+// fakeAgent stands in for the agent CLI.
+type fakeAgent func(prompt string) error
+
+func (f fakeAgent) Run(_ context.Context, prompt string, _ orchestrator.RunConfig) (orchestrator.Result, error) {
+	return orchestrator.Result{}, f(prompt)
+}
+
+func TestRebaseConflictResolution(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_NAME", "t")
+	t.Setenv("GIT_AUTHOR_EMAIL", "t@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "t")
+	t.Setenv("GIT_COMMITTER_EMAIL", "t@example.com")
+	for _, resolve := range []bool{false, true} {
+		dir, origin, other := t.TempDir(), t.TempDir(), t.TempDir()
+		git := func(dir string, args ...string) string {
+			t.Helper()
+			out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+			if err != nil {
+				t.Fatalf("git %v: %v\n%s", args, err, out)
+			}
+			return strings.TrimSpace(string(out))
+		}
+		write := func(dir, body string) {
+			t.Helper()
+			if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git(origin, "init", "-q", "--bare", "-b", "main")
+		git(dir, "clone", "-q", origin, ".")
+		write(dir, "# api\n")
+		git(dir, "add", ".")
+		git(dir, "commit", "-qm", "init")
+		git(dir, "push", "-q", "origin", "main")
+		base := git(dir, "rev-parse", "HEAD")
+		git(dir, "checkout", "-q", "-b", "agent/readme")
+		write(dir, "# api\nagent line\n")
+		git(dir, "commit", "-qam", "agent edit")
+		head := git(dir, "rev-parse", "HEAD")
+		git(other, "clone", "-q", origin, ".")
+		write(other, "# api\nupstream line\n")
+		git(other, "commit", "-qam", "upstream edit")
+		git(other, "push", "-q", "origin", "main")
+
+		var prompts []string
+		repo := spec.RepoSpec{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"}
+		r := Runner{
+			Spec: &spec.Spec{
+				Workspace: spec.Workspace{
+					AutoCommit: true,
+					Rebase:     spec.Rebase{Enabled: true, Agent: "resolver", MaxRounds: 2},
+					Repos:      []spec.RepoSpec{repo},
+				},
+				Constraints: spec.Constraints{MaxIterations: 5, MaxDiffLines: 800},
+			},
+			Orchestrator: fakeAgent(func(prompt string) error {
+				prompts = append(prompts, prompt)
+				if resolve {
+					write(dir, "# api\nagent line\nupstream line\n")
+				}
+				return nil
+			}),
+		}
+		st := &runState{
+			branchName:   "agent/readme",
+			agentPrompts: map[string]string{"resolver": "resolve the conflicts"},
+			repos:        []repoState{{spec: repo, path: dir, baseRef: base, createdBranch: true}},
+		}
+
+		err := r.rebaseRepos(context.Background(), st)
+		if len(prompts) != 1 || !strings.Contains(prompts[0], filepath.Join(dir, "README.md")) {
+			t.Fatalf("resolve=%v: the agent should be asked once about README.md, got %d prompts", resolve, len(prompts))
+		}
+		if !resolve {
+			if err == nil || !strings.Contains(err.Error(), "left conflict markers") {
+				t.Fatalf("expected a conflict marker error, got %v", err)
+			}
+			if _, serr := os.Stat(filepath.Join(dir, ".git", "rebase-merge")); !os.IsNotExist(serr) {
+				t.Error("the rebase should be aborted")
+			}
+			if got := git(dir, "rev-parse", "HEAD"); got != head {
+				t.Errorf("branch moved to %s, want %s", got, head)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		git(dir, "merge-base", "--is-ancestor", "origin/main", "HEAD")
+		if b, _ := os.ReadFile(filepath.Join(dir, "README.md")); string(b) != "# api\nagent line\nupstream line\n" {
+			t.Errorf("README.md: %q", b)
+		}
+		if status := git(dir, "status", "--porcelain"); !st.repos[0].rebased || status != "" {
+			t.Errorf("rebase should be complete and clean, status:\n%s", status)
+		}
+	}
+}
+
+func TestRebaseContinuedBranchKeepsRunDiff(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_NAME", "t")
+	t.Setenv("GIT_AUTHOR_EMAIL", "t@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "t")
+	t.Setenv("GIT_COMMITTER_EMAIL", "t@example.com")
+	for _, local := range []bool{true, false} {
+		dir, origin, other := t.TempDir(), t.TempDir(), t.TempDir()
+		git := func(dir string, args ...string) string {
+			t.Helper()
+			out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+			if err != nil {
+				t.Fatalf("git %v: %v\n%s", args, err, out)
+			}
+			return strings.TrimSpace(string(out))
+		}
+		commit := func(dir, name string) {
+			t.Helper()
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("package api\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			git(dir, "add", name)
+			git(dir, "commit", "-qm", "add "+name)
+		}
+		git(origin, "init", "-q", "--bare", "-b", "main")
+		git(dir, "clone", "-q", origin, ".")
+		git(dir, "commit", "-q", "--allow-empty", "-m", "init")
+		git(dir, "push", "-q", "origin", "main")
+		git(dir, "checkout", "-q", "-b", "agent/orders")
+		commit(dir, "earlier.go")
+		earlier := git(dir, "rev-parse", "HEAD")
+		git(dir, "push", "-q", "origin", "agent/orders")
+		git(dir, "checkout", "-q", "main")
+		if !local {
+			git(dir, "branch", "-q", "-D", "agent/orders")
+		}
+
+		ctx := context.Background()
+		r := Runner{
+			Spec: &spec.Spec{
+				Workspace: spec.Workspace{
+					AutoCommit: true,
+					Rebase:     spec.Rebase{Enabled: true},
+					Repos:      []spec.RepoSpec{{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"}},
+				},
+				Constraints: spec.Constraints{MaxIterations: 5, MaxDiffLines: 800},
+			},
+			Opts: Options{Branch: "agent/orders"},
+		}
+		st := &runState{}
+		if err := r.openRepos(ctx, st); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.setupWorkspace(ctx, st); err != nil {
+			t.Fatalf("local=%v: %v", local, err)
+		}
+		rs := &st.repos[0]
+		if !rs.continued || rs.createdBranch || rs.baseRef != earlier || git(dir, "branch", "--show-current") != "agent/orders" {
+			t.Fatalf("local=%v: branch not continued: %+v", local, rs)
+		}
+
+		commit(dir, "run.go")
+		git(other, "clone", "-q", origin, ".")
+		commit(other, "upstream.go")
+		git(other, "push", "-q", "origin", "main")
+
+		if err := r.rebaseRepos(ctx, st); err != nil {
+			t.Fatalf("local=%v: %v", local, err)
+		}
+		git(dir, "merge-base", "--is-ancestor", "origin/main", "HEAD")
+		files, err := repoChangedFiles(ctx, *rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(files, ",") != "run.go" {
+			t.Errorf("local=%v: the run's diff should only hold run.go, got %v", local, files)
+		}
+		if rs.branchHead != earlier {
+			t.Errorf("local=%v: branchHead moved to %s", local, rs.branchHead)
+		}
+	}
+}
+
+func TestRebaseResolutionOverConstraintsIsUndone(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_NAME", "t")
+	t.Setenv("GIT_AUTHOR_EMAIL", "t@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "t")
+	t.Setenv("GIT_COMMITTER_EMAIL", "t@example.com")
+	dir, origin, other := t.TempDir(), t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(dir, name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(origin, "init", "-q", "--bare", "-b", "main")
+	git(dir, "clone", "-q", origin, ".")
+	write(dir, "README.md", "# api\n")
+	git(dir, "add", ".")
+	git(dir, "commit", "-qm", "init")
+	git(dir, "push", "-q", "origin", "main")
+	base := git(dir, "rev-parse", "HEAD")
+	git(dir, "checkout", "-q", "-b", "agent/readme")
+	write(dir, "README.md", "# api\nagent line\n")
+	git(dir, "commit", "-qam", "agent edit")
+	head := git(dir, "rev-parse", "HEAD")
+	git(other, "clone", "-q", origin, ".")
+	write(other, "README.md", "# api\nupstream line\n")
+	git(other, "commit", "-qam", "upstream edit")
+	git(other, "push", "-q", "origin", "main")
+
+	repo := spec.RepoSpec{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"}
+	r := Runner{
+		Spec: &spec.Spec{
+			Workspace: spec.Workspace{
+				Rebase: spec.Rebase{Enabled: true, Agent: "resolver", MaxRounds: 2},
+				Repos:  []spec.RepoSpec{repo},
+			},
+			Constraints: spec.Constraints{MaxIterations: 5, MaxDiffLines: 10},
+		},
+		Orchestrator: fakeAgent(func(string) error {
+			write(dir, "README.md", "# api\nagent line\nupstream line\n")
+			write(dir, "extra.go", strings.Repeat("// filler\n", 20))
+			return nil
+		}),
+	}
+	st := &runState{
+		agentPrompts: map[string]string{"resolver": "resolve the conflicts"},
+		repos:        []repoState{{spec: repo, path: dir, baseRef: base, createdBranch: true}},
+	}
+	err := r.rebaseRepos(context.Background(), st)
+	if err == nil || !strings.Contains(err.Error(), "conflict resolution: diff line limit exceeded") {
+		t.Fatalf("expected a constraint error, got %v", err)
+	}
+	if got := git(dir, "rev-parse", "HEAD"); got != head || st.repos[0].baseRef != base {
+		t.Errorf("branch should be put back at %s, got %s (base %s)", head, got, st.repos[0].baseRef)
+	}
+}
+
+func TestRebaseSkipsReposWithoutUpstream(t *testing.T) {
+	dir, origin := t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git(origin, "init", "-q", "--bare", "-b", "main")
+	git(dir, "clone", "-q", origin, ".")
+	git(dir, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+	git(dir, "push", "-q", "origin", "main")
+
+	repo := spec.RepoSpec{Name: "api", Path: dir, BaseBranch: "develop", Remote: "origin", PushRemote: "origin"}
+	r := Runner{Spec: &spec.Spec{Workspace: spec.Workspace{Rebase: spec.Rebase{Enabled: true}, Repos: []spec.RepoSpec{repo}}}}
+	st := &runState{repos: []repoState{{spec: repo, path: dir}}}
+	if err := r.rebaseRepos(context.Background(), st); err != nil {
+		t.Fatalf("base branch not on the remote: %v", err)
+	}
+	git(dir, "remote", "remove", "origin")
+	if err := r.rebaseRepos(context.Background(), st); err != nil {
+		t.Fatalf("no remote: %v", err)
+	}
+	if st.repos[0].rebased {
+		t.Error("nothing should be rebased")
+	}
+}
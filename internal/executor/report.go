@@ -0,0 +1,353 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+	"github.com/threatlevelmidnight10/devspec/internal/orchestrator"
+	"github.com/threatlevelmidnight10/devspec/internal/prompt"
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+// maxStepOutput bounds how much of a shell step's output ends up in the
+// pull request body.
+const maxStepOutput = 2000
+
+// stepResult records how a step went, for the pull request body.
+type stepResult struct {
+	Name     string
+	Kind     string // "agent" or "shell"
+	Agent    string
+	Model    string
+	Mode     string
+	Command  string
+	Passed   bool
+	Output   string // tail of a shell step's output
+	Duration time.Duration
+}
+
+// constraintCheck is the outcome of one constraints setting over the whole
+// run.
+type constraintCheck struct {
+	Name   string
+	Detail string
+	OK     bool
+}
+
+// prRepo is what a pull request changes in one repo: DiffStat and Files
+// cover the whole pull request, Iteration only this run.
+type prRepo struct {
+	Name       string
+	BaseBranch string
+	DiffStat   string
+	Files      []string
+	Iteration  string
+}
+
+// prData is what output.pr_template is rendered with.
+type prData struct {
+	Spec        string
+	Title       string // the issue title, or the first line of the task
+	Task        string
+	Acceptance  []string
+	Labels      []string // issue labels
+	Feedback    string
+	Plan        string
+	Summary     string
+	Model       string
+	RunID       string
+	Branch      string
+	Repo        string // the repo this pull request is for
+	DiffStat    string // diffstat of Repo
+	Repos       []prRepo
+	Steps       []stepResult
+	Checks      []stepResult // shell steps
+	Constraints []constraintCheck
+}
+
+func (r *Runner) newStepResult(step spec.Step) stepResult {
+	if strings.TrimSpace(step.Run) != "" {
+		return stepResult{Name: step.Name, Kind: "shell", Command: step.Run, Passed: true}
+	}
+	mode := step.Mode
+	if mode == "" {
+		mode = "agent"
+	}
+	return stepResult{
+		Name:   step.Name,
+		Kind:   "agent",
+		Agent:  step.Agent,
+		Model:  r.Spec.EffectiveAgentModel(step.Agent, r.Opts.ModelOverride),
+		Mode:   mode,
+		Passed: true,
+	}
+}
+
+// tailOutput keeps the end of a command's output, where failures are
+// usually reported.
+func tailOutput(out string) string {
+	out = strings.TrimSpace(out)
+	if len(out) <= maxStepOutput {
+		return out
+	}
+	return "…" + out[len(out)-maxStepOutput:]
+}
+
+// constraintChecks reports how the run's changes, across all repos, measure
+// against the spec's constraints.
+func (r *Runner) constraintChecks(ctx context.Context, st *runState) ([]constraintCheck, error) {
+	c := r.Spec.Constraints
+	lines, anyFiles, hasTests, err := diffTotals(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	checks := []constraintCheck{
+		{
+			Name:   "max_diff_lines",
+			Detail: fmt.Sprintf("%d of %d lines", lines, c.MaxDiffLines),
+			OK:     lines <= c.MaxDiffLines,
+		},
+		{
+			Name:   "max_iterations",
+			Detail: fmt.Sprintf("%d of %d iterations", st.mutationIterations, c.MaxIterations),
+			OK:     st.mutationIterations <= c.MaxIterations,
+		},
+	}
+	if c.RequireTests {
+		detail := "test files modified"
+		if !hasTests {
+			detail = "no test files modified"
+		}
+		checks = append(checks, constraintCheck{Name: "require_tests", Detail: detail, OK: hasTests || !anyFiles})
+	}
+	return checks, nil
+}
+
+// prSummary asks output.summary_agent to summarize the run's changes. It
+// returns "" when no summary agent is configured.
+func (r *Runner) prSummary(ctx context.Context, st *runState) (string, error) {
+	name := strings.TrimSpace(r.Spec.Output.SummaryAgent)
+	if name == "" {
+		return "", nil
+	}
+	var diffs []string
+	for _, rs := range st.repos {
+		d, err := repoDiff(ctx, rs)
+		if err != nil {
+			return "", err
+		}
+		if d != "" {
+			diffs = append(diffs, fmt.Sprintf("==> %s:\n%s", rs.spec.Name, d))
+		}
+	}
+	p, err := r.renderPrompt(st, prompt.KindSummary, nil, prompt.Inputs{
+		Spec:          r.Spec,
+		Task:          r.Opts.Task,
+		Acceptance:    r.Opts.Acceptance,
+		SummaryPrompt: st.agentPrompts[name],
+		PlanOutput:    st.planOutput,
+		DiffOutput:    strings.Join(diffs, "\n\n"),
+	})
+	if err != nil {
+		return "", err
+	}
+	out, err := r.Orchestrator.Run(ctx, p, orchestrator.RunConfig{
+		Model:         r.Spec.EffectiveAgentModel(name, r.Opts.ModelOverride),
+		Mode:          "ask",
+		WorkspacePath: st.workspacePath,
+	})
+	if err != nil {
+		return "", fmt.Errorf("summary agent %q: %w", name, err)
+	}
+	return cleanAgentMessage(out.Stdout), nil
+}
+
+// prReport gathers the run data shared by every pull request of the run.
+func (r *Runner) prReport(ctx context.Context, st *runState) (prData, error) {
+	data := prData{
+		Spec:       r.Spec.Name,
+		Title:      r.taskTitle(),
+		Task:       r.Opts.Task,
+		Acceptance: r.Opts.Acceptance,
+		Labels:     r.Opts.Labels,
+		Feedback:   strings.TrimSpace(r.Opts.Feedback),
+		Plan:       strings.TrimSpace(st.planOutput),
+		Model:      r.prModel(st.steps),
+		RunID:      st.runID,
+		Branch:     st.branchName,
+		Steps:      st.steps,
+	}
+	for _, res := range st.steps {
+		if res.Kind == "shell" {
+			data.Checks = append(data.Checks, res)
+		}
+	}
+	for _, rs := range st.repos {
+		files, err := repoChangedFiles(ctx, rs)
+		if err != nil {
+			return data, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		iteration, err := gitutil.DiffStatSince(ctx, rs.path, rs.baseRef)
+		if err != nil {
+			return data, err
+		}
+		// A continued branch holds earlier iterations too, and the pull
+		// request body describes all of them.
+		base := prBase(ctx, rs)
+		stat, err := gitutil.DiffStatSince(ctx, rs.path, base)
+		if err != nil {
+			return data, err
+		}
+		if files, err = gitutil.ChangedFilesSince(ctx, rs.path, base); err != nil {
+			return data, err
+		}
+		data.Repos = append(data.Repos, prRepo{
+			Name:       rs.spec.Name,
+			BaseBranch: rs.spec.BaseBranch,
+			DiffStat:   strings.TrimRight(stat, "\n"),
+			Files:      files,
+			Iteration:  strings.TrimRight(iteration, "\n"),
+		})
+	}
+	checks, err := r.constraintChecks(ctx, st)
+	if err != nil {
+		return data, err
+	}
+	data.Constraints = checks
+	summary, err := r.prSummary(ctx, st)
+	if err != nil {
+		return data, err
+	}
+	data.Summary = summary
+	return data, nil
+}
+
+// prBase returns the commit the pull request of rs starts from: where the
+// branch forked from the base branch, preferably the remote's copy of it.
+// Without either it falls back to where the run started.
+func prBase(ctx context.Context, rs repoState) string {
+	for _, ref := range []string{rs.spec.Remote + "/" + rs.spec.BaseBranch, rs.spec.BaseBranch} {
+		if base, err := gitutil.MergeBase(ctx, rs.path, "HEAD", ref); err == nil {
+			return base
+		}
+	}
+	return rs.baseRef
+}
+
+// prModel names the model of the run: the one every agent step used, or
+// each agent step's model when they differ.
+func (r *Runner) prModel(steps []stepResult) string {
+	var perStep []string
+	model := ""
+	same := true
+	for _, res := range steps {
+		if res.Kind != "agent" {
+			continue
+		}
+		if model == "" {
+			model = res.Model
+		}
+		same = same && res.Model == model
+		perStep = append(perStep, res.Name+": "+res.Model)
+	}
+	switch {
+	case model == "":
+		return r.Spec.EffectiveModel(r.Opts.ModelOverride)
+	case same:
+		return model
+	}
+	return strings.Join(perStep, ", ")
+}
+
+// renderPRBody renders output.pr_template for the pull request of one repo.
+// A template that does not parse, say a repo's own template with a literal
+// "{{", is used as is.
+func (r *Runner) renderPRBody(data prData, repo string) (string, error) {
+	path := r.Spec.ResolvePath(r.Spec.Output.PRTemplate)
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("pr template not found: %w", err)
+	}
+	tmpl, err := template.New("pr").Parse(string(raw))
+	if err != nil {
+		fmt.Printf("warning: pr template %s is used as is: %v\n", path, err)
+		return strings.TrimSpace(string(raw)) + "\n", nil
+	}
+	body, err := renderPR("pr template "+path, tmpl, data, repo)
+	if err != nil {
+		return "", err
+	}
+	return body + "\n", nil
+}
+
+// renderPRTitle renders output.pr.title, on a single line.
+func (r *Runner) renderPRTitle(data prData, repo string) (string, error) {
+	tmpl, err := template.New("pr").Parse(r.Spec.Output.PR.Title)
+	if err != nil {
+		return "", fmt.Errorf("parse output.pr.title: %w", err)
+	}
+	title, err := renderPR("output.pr.title", tmpl, data, repo)
+	if err != nil {
+		return "", err
+	}
+	title = strings.Join(strings.Fields(title), " ")
+	if title == "" {
+		return "", errors.New("output.pr.title rendered an empty title")
+	}
+	return title, nil
+}
+
+func renderPR(name string, tmpl *template.Template, data prData, repo string) (string, error) {
+	data.Repo = repo
+	for _, rp := range data.Repos {
+		if rp.Name == repo {
+			data.DiffStat = rp.DiffStat
+		}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render %s: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// iterationComment summarizes a run that pushed to the branch of an
+// already open pull request.
+func iterationComment(data prData, repo string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "devspec run `%s` pushed a new iteration.\n\n", data.RunID)
+	if data.Feedback != "" {
+		fmt.Fprintf(&b, "**Review feedback addressed:**\n\n%s\n\n", data.Feedback)
+	} else {
+		fmt.Fprintf(&b, "**Task:** %s\n\n", data.Task)
+	}
+	for _, rp := range data.Repos {
+		if rp.Name == repo && rp.Iteration != "" {
+			fmt.Fprintf(&b, "```\n%s\n```\n\n", rp.Iteration)
+		}
+	}
+	for _, t := range data.Checks {
+		fmt.Fprintf(&b, "- %s `%s`\n", checkMark(t.Passed), t.Command)
+	}
+	for _, c := range data.Constraints {
+		fmt.Fprintf(&b, "- %s %s: %s\n", checkMark(c.OK), c.Name, c.Detail)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func checkMark(ok bool) string {
+	if ok {
+		return "✅"
+	}
+	return "❌"
+}
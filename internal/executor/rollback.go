@@ -0,0 +1,100 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// rollback returns every repo to the branch and commit it was on before the
+// run, for workspace.atomic runs that failed. Agent branches created by the
+// run are deleted, locally and on the push remote; continued branches are
+// reset to where they were. Stashes are restored afterwards by restoreRepos.
+func (r *Runner) rollback(ctx context.Context, st *runState) error {
+	var errs []error
+	var report []string
+	for i := len(st.repos) - 1; i >= 0; i-- {
+		rs := &st.repos[i]
+		actions, err := r.rollbackRepo(ctx, st, rs)
+		if len(actions) > 0 {
+			report = append(report, fmt.Sprintf("  %s: %s", rs.spec.Name, strings.Join(actions, ", ")))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("repo %q rollback: %w", rs.spec.Name, err))
+		}
+	}
+	if len(report) > 0 {
+		fmt.Println("rolled back:")
+		for i := len(report) - 1; i >= 0; i-- {
+			fmt.Println(report[i])
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Runner) rollbackRepo(ctx context.Context, st *runState, rs *repoState) ([]string, error) {
+	var actions []string
+	branch := st.branchName
+	if !rs.touched {
+		return nil, nil
+	}
+
+	if rs.worktreeOf != "" {
+		if err := gitutil.WorktreeRemove(ctx, rs.worktreeOf, rs.path); err != nil {
+			return actions, err
+		}
+		actions = append(actions, "removed worktree "+rs.path)
+		rs.path, rs.worktreeOf = rs.worktreeOf, ""
+	} else {
+		// A rebase may have been interrupted half way.
+		_ = gitutil.RebaseAbort(ctx, rs.path)
+		if err := gitutil.ResetHard(ctx, rs.path, "HEAD"); err != nil {
+			return actions, err
+		}
+		if err := gitutil.Clean(ctx, rs.path); err != nil {
+			return actions, err
+		}
+		actions = append(actions, "discarded uncommitted changes")
+		if err := gitutil.Checkout(ctx, rs.path, rs.origBranch); err != nil {
+			return actions, err
+		}
+		if err := gitutil.ResetHard(ctx, rs.path, rs.origHead); err != nil {
+			return actions, err
+		}
+		actions = append(actions, fmt.Sprintf("checked out %s at %s", rs.origBranch, shortHash(rs.origHead)))
+	}
+
+	if branch != "" && branch != rs.origBranch {
+		switch {
+		case rs.createdBranch:
+			if err := gitutil.DeleteBranch(ctx, rs.path, branch); err != nil {
+				return actions, err
+			}
+			actions = append(actions, "deleted branch "+branch)
+		case rs.continued:
+			if err := gitutil.SetBranch(ctx, rs.path, branch, rs.branchHead); err != nil {
+				return actions, err
+			}
+			actions = append(actions, fmt.Sprintf("reset branch %s to %s", branch, shortHash(rs.branchHead)))
+		}
+	}
+
+	if rs.pushed {
+		remote := rs.spec.PushRemote
+		if rs.continued {
+			if err := gitutil.PushRefTo(ctx, rs.path, remote, rs.branchHead, branch); err != nil {
+				return actions, err
+			}
+			actions = append(actions, fmt.Sprintf("reset %s/%s to %s", remote, branch, shortHash(rs.branchHead)))
+		} else {
+			if err := gitutil.DeleteRemoteBranch(ctx, rs.path, remote, branch); err != nil {
+				return actions, err
+			}
+			actions = append(actions, fmt.Sprintf("deleted %s/%s", remote, branch))
+		}
+	}
+	return actions, nil
+}
@@ -0,0 +1,188 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+func TestRollbackDeletesCreatedBranch(t *testing.T) {
+	dir, origin := t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(origin, "init", "-q", "--bare", "-b", "main")
+	git(dir, "clone", "-q", origin, ".")
+	git(dir, "config", "user.name", "t")
+	git(dir, "config", "user.email", "t@example.com")
+	git(dir, "commit", "-q", "--allow-empty", "-m", "init")
+	git(dir, "push", "-q", "origin", "main")
+	origHead := git(dir, "rev-parse", "HEAD")
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{
+			{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"},
+		}}},
+		Opts: Options{Branch: "agent/new"},
+	}
+	st := &runState{}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setupWorkspace(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.repos[0].createdBranch {
+		t.Fatal("branch should be created")
+	}
+	git(dir, "commit", "-q", "--allow-empty", "-m", "this run")
+	git(dir, "push", "-q", "origin", "agent/new")
+	st.repos[0].pushed = true
+	if err := os.WriteFile(filepath.Join(dir, "leftover.go"), []byte("package api\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.rollback(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if out := git(dir, "branch", "--list", "agent/new") + git(origin, "branch", "--list", "agent/new"); out != "" {
+		t.Errorf("branch should be deleted locally and on the remote: %q", out)
+	}
+	if branch, head := git(dir, "branch", "--show-current"), git(dir, "rev-parse", "HEAD"); branch != "main" || head != origHead {
+		t.Errorf("on %s at %s, want main at %s", branch, head, origHead)
+	}
+	if status := git(dir, "status", "--porcelain"); status != "" {
+		t.Errorf("leftover changes should be discarded:\n%s", status)
+	}
+}
+
+func TestRollbackResetsContinuedBranch(t *testing.T) {
+	dir, origin := t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(origin, "init", "-q", "--bare", "-b", "main")
+	git(dir, "clone", "-q", origin, ".")
+	git(dir, "config", "user.name", "t")
+	git(dir, "config", "user.email", "t@example.com")
+	git(dir, "commit", "-q", "--allow-empty", "-m", "init")
+	git(dir, "push", "-q", "origin", "main")
+	git(dir, "checkout", "-q", "-b", "agent/orders")
+	git(dir, "commit", "-q", "--allow-empty", "-m", "earlier iteration")
+	earlier := git(dir, "rev-parse", "HEAD")
+	git(dir, "push", "-q", "origin", "agent/orders")
+	git(dir, "checkout", "-q", "main")
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{
+			{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"},
+		}}},
+		Opts: Options{Branch: "agent/orders"},
+	}
+	st := &runState{}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setupWorkspace(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if !st.repos[0].continued {
+		t.Fatal("branch should be continued")
+	}
+	git(dir, "commit", "-q", "--allow-empty", "-m", "this run")
+	git(dir, "push", "-q", "origin", "agent/orders")
+	st.repos[0].pushed = true
+
+	if err := r.rollback(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if local, remote := git(dir, "rev-parse", "agent/orders"), git(origin, "rev-parse", "agent/orders"); local != earlier || remote != earlier {
+		t.Errorf("branch at %s, on the remote at %s, want %s", local, remote, earlier)
+	}
+	if branch := git(dir, "branch", "--show-current"); branch != "main" {
+		t.Errorf("on %s, want main", branch)
+	}
+}
+
+func TestRollbackRestoresOriginalBranch(t *testing.T) {
+	dir, origin, other := t.TempDir(), t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(dir, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(origin, "init", "-q", "--bare", "-b", "main")
+	git(dir, "clone", "-q", origin, ".")
+	write(dir, "# api\n")
+	git(dir, "add", ".")
+	git(dir, "commit", "-qm", "init")
+	git(dir, "push", "-q", "origin", "main")
+	git(dir, "checkout", "-q", "-b", "topic")
+	git(dir, "commit", "-q", "--allow-empty", "-m", "topic work")
+	origHead := git(dir, "rev-parse", "HEAD")
+
+	ctx := context.Background()
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{
+			{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"},
+		}}},
+		Opts: Options{Branch: "agent/readme"},
+	}
+	st := &runState{}
+	if err := r.openRepos(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setupWorkspace(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	write(dir, "# api\nagent line\n")
+	git(dir, "commit", "-qam", "agent edit")
+
+	git(other, "clone", "-q", origin, ".")
+	write(other, "# api\nupstream line\n")
+	git(other, "commit", "-qam", "upstream edit")
+	git(other, "push", "-q", "origin", "main")
+	git(dir, "fetch", "-q", "origin")
+	if err := exec.Command("git", "-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com", "rebase", "origin/main").Run(); err == nil {
+		t.Fatal("rebase should stop on the conflict")
+	}
+
+	if err := r.rollback(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	if branch, head := git(dir, "branch", "--show-current"), git(dir, "rev-parse", "HEAD"); branch != "topic" || head != origHead {
+		t.Errorf("on %q at %s, want topic at %s", branch, head, origHead)
+	}
+	if status := git(dir, "status", "--porcelain"); status != "" {
+		t.Errorf("tree should be clean:\n%s", status)
+	}
+	if out := git(dir, "branch", "--list", "agent/readme"); out != "" {
+		t.Errorf("agent branch should be deleted: %q", out)
+	}
+}
@@ -0,0 +1,360 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+// ruleFile is a file written into every repo for the run. Appended files
+// keep what the repo already has and add the rules after it.
+type ruleFile struct {
+	rel    string
+	body   string
+	append bool
+}
+
+// ruleTargetFiles are the files rules of each appending target go to.
+var ruleTargetFiles = map[string]string{"agents": "AGENTS.md", "claude": "CLAUDE.md"}
+
+// injectedRules records what injectRules changed in a repo, so that
+// restoreRules can undo it.
+type injectedRules struct {
+	files      []injectedFile
+	dirs       []string // created for the rules, outermost first
+	exclude    string
+	hadExclude bool
+}
+
+// The rules of a run are listed in a marked block of info/exclude, with
+// notes on the tracked files they changed and the files they created, so
+// that the next run can clean up after one that was killed.
+const (
+	excludeBegin = "# devspec: rules written for the current run"
+	excludeEnd   = "# devspec: end of rules"
+	skipNote     = "# skip-worktree: "
+	createdNote  = "# created: "
+)
+
+type injectedFile struct {
+	path    string
+	rel     string
+	orig    []byte
+	existed bool
+	tracked bool
+}
+
+func (r *Runner) loadRules(st *runState) error {
+	appended := make(map[string][]string)
+	for _, rule := range r.Spec.Rules {
+		body, err := r.resolveContent(rule.Content)
+		if err != nil {
+			return fmt.Errorf("resolve rule %q: %w", rule.Name, err)
+		}
+		target := rule.Target
+		if target == "" {
+			target = r.Spec.DefaultRuleTarget()
+		}
+		if target == "cursor" {
+			st.ruleFiles = append(st.ruleFiles, ruleFile{rel: ".cursor/rules/" + rule.Name + ".mdc", body: cursorRule(rule, body)})
+			continue
+		}
+		appended[target] = append(appended[target], body)
+	}
+	for _, target := range spec.RuleTargets {
+		if bodies := appended[target]; len(bodies) > 0 {
+			st.ruleFiles = append(st.ruleFiles, ruleFile{rel: ruleTargetFiles[target], body: strings.Join(bodies, "\n\n") + "\n", append: true})
+		}
+	}
+	return nil
+}
+
+// cursorRule renders a Cursor .mdc rule. A rule without globs or a
+// description always applies. Content that has its own frontmatter is kept
+// as it is.
+func cursorRule(rule spec.Rule, body string) string {
+	if strings.HasPrefix(body, "---\n") {
+		return body + "\n"
+	}
+	return fmt.Sprintf("---\ndescription: %s\nglobs: %s\nalwaysApply: %t\n---\n\n%s\n",
+		strings.Join(strings.Fields(rule.Description), " "),
+		strings.Join(rule.Globs, ","),
+		len(rule.Globs) == 0 && strings.TrimSpace(rule.Description) == "",
+		body)
+}
+
+// injectRules writes the rules into every repo. New files are listed in
+// the repo's info/exclude and tracked ones are marked skip-worktree, so
+// git ignores them: they are never committed or counted in the diff.
+func (r *Runner) injectRules(ctx context.Context, st *runState) error {
+	if len(st.ruleFiles) == 0 {
+		return nil
+	}
+	var rels []string
+	for _, rf := range st.ruleFiles {
+		rels = append(rels, rf.rel)
+	}
+	if r.Opts.DryRun {
+		fmt.Printf("dry-run: would write rules: %s\n", strings.Join(rels, ", "))
+		return nil
+	}
+	for i := range st.repos {
+		rs := &st.repos[i]
+		rs.rules = &injectedRules{}
+		if err := injectRepoRules(ctx, rs.path, rs.rules, st.ruleFiles); err != nil {
+			return fmt.Errorf("repo %q rules: %w", rs.spec.Name, err)
+		}
+	}
+	fmt.Printf("wrote rules for the run: %s\n", strings.Join(rels, ", "))
+	return nil
+}
+
+func injectRepoRules(ctx context.Context, dir string, in *injectedRules, files []ruleFile) error {
+	exclude, err := gitutil.ExcludeFile(ctx, dir)
+	if err != nil {
+		return err
+	}
+	orig, err := os.ReadFile(exclude)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	in.exclude, in.hadExclude = exclude, err == nil
+
+	block := []string{excludeBegin}
+	for _, rf := range files {
+		f := injectedFile{path: filepath.Join(dir, filepath.FromSlash(rf.rel)), rel: rf.rel}
+		b, err := os.ReadFile(f.path)
+		switch {
+		case err == nil:
+			f.orig, f.existed = b, true
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+		if f.tracked, err = gitutil.IsTracked(ctx, dir, rf.rel); err != nil {
+			return err
+		}
+		switch {
+		case f.tracked:
+			block = append(block, skipNote+rf.rel)
+		case !f.existed:
+			block = append(block, createdNote+rf.rel, "/"+rf.rel)
+		default:
+			block = append(block, "/"+rf.rel)
+		}
+		in.files = append(in.files, f)
+	}
+
+	// The block goes in first, so that it covers whatever is written next.
+	text := strings.Join(append(block, excludeEnd), "\n") + "\n"
+	if len(orig) > 0 && !strings.HasSuffix(string(orig), "\n") {
+		text = "\n" + text
+	}
+	if err := os.MkdirAll(filepath.Dir(exclude), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(exclude, append(slices.Clone(orig), text...), 0o644); err != nil {
+		return err
+	}
+
+	for i, rf := range files {
+		f := in.files[i]
+		body := rf.body
+		if rf.append && f.existed {
+			body = strings.TrimRight(string(f.orig), "\n") + "\n\n" + body
+		}
+		created, err := mkdirs(filepath.Dir(f.path))
+		in.dirs = append(in.dirs, created...)
+		if err != nil {
+			return err
+		}
+		if f.tracked {
+			if err := gitutil.SetSkipWorktree(ctx, dir, true, rf.rel); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(f.path, []byte(body), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreRules puts back what injectRules replaced and removes what it
+// added. Changes agents made to the rule files are discarded.
+func (r *Runner) restoreRules(ctx context.Context, st *runState) error {
+	var errs []error
+	for i := range st.repos {
+		rs := &st.repos[i]
+		if rs.rules == nil {
+			continue
+		}
+		if err := restoreRepoRules(ctx, rs.path, rs.rules); err != nil {
+			errs = append(errs, fmt.Errorf("repo %q restore rules: %w", rs.spec.Name, err))
+		}
+		rs.rules = nil
+	}
+	return errors.Join(errs...)
+}
+
+func restoreRepoRules(ctx context.Context, dir string, in *injectedRules) error {
+	var errs []error
+	for i := len(in.files) - 1; i >= 0; i-- {
+		f := in.files[i]
+		var err error
+		if f.existed {
+			err = os.WriteFile(f.path, f.orig, 0o644)
+		} else if err = os.Remove(f.path); errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if f.tracked {
+			if err := gitutil.SetSkipWorktree(ctx, dir, false, f.rel); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	// Directories the agent added files to are left in place.
+	for i := len(in.dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(in.dirs[i])
+	}
+	if in.exclude != "" {
+		if err := dropExcludeBlock(in.exclude, !in.hadExclude); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cleanupRules undoes what runs that were killed before restoreRules left
+// behind: rule files they created, the content and skip-worktree bits of
+// tracked files they changed, and their info/exclude block.
+func (r *Runner) cleanupRules(ctx context.Context, st *runState) error {
+	for _, rs := range st.repos {
+		cleaned, err := cleanupRepoRules(ctx, rs.path)
+		if err != nil {
+			return fmt.Errorf("repo %q: clean up rules of an earlier run: %w", rs.spec.Name, err)
+		}
+		if len(cleaned) > 0 {
+			fmt.Printf("removed rules left by an earlier run in %s: %s\n", rs.spec.Name, strings.Join(cleaned, ", "))
+		}
+	}
+	return nil
+}
+
+func cleanupRepoRules(ctx context.Context, dir string) ([]string, error) {
+	exclude, err := gitutil.ExcludeFile(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(exclude)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, block := splitExclude(string(b))
+	if len(block) == 0 {
+		return nil, nil
+	}
+	var cleaned []string
+	for _, line := range block {
+		if rel, ok := strings.CutPrefix(line, skipNote); ok {
+			// The bit is per worktree; it may have been set in another one.
+			skip, err := gitutil.IsSkipWorktree(ctx, dir, rel)
+			if err != nil {
+				return cleaned, err
+			}
+			if !skip {
+				continue
+			}
+			if err := gitutil.SetSkipWorktree(ctx, dir, false, rel); err != nil {
+				return cleaned, err
+			}
+			if err := gitutil.RestoreFiles(ctx, dir, rel); err != nil {
+				return cleaned, err
+			}
+			cleaned = append(cleaned, rel)
+		} else if rel, ok := strings.CutPrefix(line, createdNote); ok {
+			path := filepath.Join(dir, filepath.FromSlash(rel))
+			if err := os.Remove(path); errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return cleaned, err
+			}
+			// Remove the directories it was the last file in.
+			for d := filepath.Dir(path); d != dir; d = filepath.Dir(d) {
+				if os.Remove(d) != nil {
+					break
+				}
+			}
+			cleaned = append(cleaned, rel)
+		}
+	}
+	return cleaned, dropExcludeBlock(exclude, false)
+}
+
+// dropExcludeBlock removes the devspec block from the info/exclude file,
+// and the file itself if removeEmpty is set and nothing else is left.
+func dropExcludeBlock(exclude string, removeEmpty bool) error {
+	b, err := os.ReadFile(exclude)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rest, _ := splitExclude(string(b))
+	if removeEmpty && strings.TrimSpace(rest) == "" {
+		return os.Remove(exclude)
+	}
+	return os.WriteFile(exclude, []byte(rest), 0o644)
+}
+
+// splitExclude separates the devspec blocks of an info/exclude file from
+// the rest of it, returning the lines inside the blocks.
+func splitExclude(content string) (rest string, block []string) {
+	var b strings.Builder
+	in := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		switch trimmed := strings.TrimRight(line, "\n"); {
+		case trimmed == excludeBegin:
+			in = true
+		case trimmed == excludeEnd && in:
+			in = false
+		case in:
+			block = append(block, trimmed)
+		default:
+			b.WriteString(line)
+		}
+	}
+	return b.String(), block
+}
+
+// mkdirs creates dir and any missing parents, returning the directories it
+// created, outermost first.
+func mkdirs(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	slices.Reverse(missing)
+	return missing, os.MkdirAll(dir, 0o755)
+}
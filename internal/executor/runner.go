@@ -1,11 +1,11 @@
 package executor
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,18 +17,29 @@ import (
 	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
 	"github.com/threatlevelmidnight10/devspec/internal/orchestrator"
 	"github.com/threatlevelmidnight10/devspec/internal/prompt"
+	"github.com/threatlevelmidnight10/devspec/internal/repocontext"
+	"github.com/threatlevelmidnight10/devspec/internal/skill"
 	"github.com/threatlevelmidnight10/devspec/internal/spec"
+	"github.com/threatlevelmidnight10/devspec/internal/symbols"
 )
 
 var testFilePattern = regexp.MustCompile(`(?i)(^|/)(test|tests)(/|$)|(_test\.|\.test\.|\.spec\.)`)
 
+// Options are the per-run settings. Title, Labels and Acceptance come from
+// a task written as an issue.
 type Options struct {
 	Task            string
+	Title           string
+	Labels          []string
+	Acceptance      []string
 	DryRun          bool
 	NoPR            bool
 	KeepWorkspace   bool
 	ModelOverride   string
 	MaxIterOverride int
+	OnDirty         DirtyStrategy
+	Branch          string
+	Feedback        string
 }
 
 type Runner struct {
@@ -40,24 +51,56 @@ type Runner struct {
 }
 
 type repoState struct {
-	spec       spec.RepoSpec
-	path       string
-	beforeDiff []string
+	spec          spec.RepoSpec
+	path          string
+	beforeDiff    []string
+	baseRef       string // where the run's changes start
+	branchHead    string // head of a continued branch before the run
+	continued     bool
+	rebased       bool
+	touched       bool
+	createdBranch bool
+	pushed        bool
+	origBranch    string
+	origHead      string
+	stashRef      string
+	worktreeOf    string
+	carry         bool // --on-dirty=include: commit the changes on the agent branch
+	rules         *injectedRules
 }
 
 type runState struct {
 	repos              []repoState
 	workspaceFile      string
+	workspacePath      string
 	branchName         string
+	runID              string
 	planOutput         string
 	repoTree           string
+	files              []contextFile // context.files and plan files, inlined
+	symbols            string
+	gitLog             string
+	fileHistory        string // log and blame of the files the plan names
 	gitDiff            string
 	agentPrompts       map[string]string
-	skillBodies        []string
+	skills             []*skill.Skill
+	ruleFiles          []ruleFile
 	mutationIterations int
+	steps              []stepResult
+	promptTemplates    map[string]string // prompt template overrides by spec path
+	log                io.Writer         // progress notes; os.Stdout if nil
 }
 
-func (r *Runner) Run(ctx context.Context) error {
+// logf prints a progress note of the run.
+func (st *runState) logf(format string, args ...any) {
+	w := st.log
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
+func (r *Runner) Run(ctx context.Context) (err error) {
 	if r.Spec == nil {
 		return errors.New("spec is required")
 	}
@@ -76,7 +119,7 @@ func (r *Runner) Run(ctx context.Context) error {
 		r.Workdir = wd
 	}
 
-	st := &runState{agentPrompts: map[string]string{}}
+	st := &runState{agentPrompts: map[string]string{}, runID: newRunID(r.Now)}
 	if r.Opts.MaxIterOverride > 0 {
 		r.Spec.Constraints.MaxIterations = r.Opts.MaxIterOverride
 	}
@@ -84,7 +127,98 @@ func (r *Runner) Run(ctx context.Context) error {
 		r.Orchestrator = orchestrator.CursorRunner{Binary: r.Spec.Binary}
 	}
 
-	var stashedRepos []string
+	// Remove rules, roll back and restore stashes and worktrees even if the
+	// run fails or ctx is cancelled.
+	defer func() {
+		cleanupCtx := context.WithoutCancel(ctx)
+		if rerr := r.restoreRules(cleanupCtx, st); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+		if err != nil && r.Spec.Workspace.Atomic && !r.Opts.DryRun {
+			if rerr := r.rollback(cleanupCtx, st); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}
+		if rerr := r.restoreRepos(cleanupCtx, st, err != nil); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}()
+
+	if err := r.openRepos(ctx, st); err != nil {
+		return err
+	}
+	if !r.Opts.DryRun {
+		if err := r.cleanupRules(ctx, st); err != nil {
+			return err
+		}
+	}
+	for i := range st.repos {
+		clean, err := gitutil.IsClean(ctx, st.repos[i].path)
+		if err != nil {
+			return fmt.Errorf("repo %q: %w", st.repos[i].spec.Name, err)
+		}
+		if !clean {
+			if err := r.handleDirty(ctx, &st.repos[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.loadContent(st); err != nil {
+		return err
+	}
+	if err := r.gatherContext(ctx, st); err != nil {
+		return err
+	}
+
+	if err := r.setupWorkspace(ctx, st); err != nil {
+		return err
+	}
+	defer func() {
+		if st.workspaceFile != "" && !r.Opts.KeepWorkspace {
+			os.RemoveAll(st.workspaceFile)
+		}
+	}()
+	if err := r.injectRules(ctx, st); err != nil {
+		return err
+	}
+
+	for i, step := range r.Spec.Steps {
+		stepNum := fmt.Sprintf("[%d/%d]", i+1, len(r.Spec.Steps))
+		res := r.newStepResult(step)
+		if res.Kind == "shell" {
+			fmt.Printf("\n==> %s %s (shell)\n", stepNum, step.Name)
+		} else {
+			fmt.Printf("\n==> %s %s (agent: %s, model: %s, mode: %s)\n", stepNum, step.Name, res.Agent, res.Model, res.Mode)
+		}
+		start := time.Now()
+		if err := r.runStep(ctx, st, step, &res); err != nil {
+			return err
+		}
+		if err := r.commitStep(ctx, st, step); err != nil {
+			return err
+		}
+		elapsed := time.Since(start)
+		res.Duration = elapsed.Round(100 * time.Millisecond)
+		st.steps = append(st.steps, res)
+		fmt.Printf("  done in %.1fs\n", elapsed.Seconds())
+	}
+
+	// Rules must be gone before the final commit and rebase.
+	if err := r.restoreRules(ctx, st); err != nil {
+		return err
+	}
+	if err := r.finalize(ctx, st); err != nil {
+		return err
+	}
+
+	fmt.Printf("devspec completed successfully on branch %s\n", st.branchName)
+	return nil
+}
+
+// openRepos resolves the repos of the workspace and records where they
+// stand, without changing them.
+func (r *Runner) openRepos(ctx context.Context, st *runState) error {
 	for _, rSpec := range r.Spec.Workspace.Repos {
 		p := r.Spec.ResolvePath(rSpec.Path)
 		if err := gitutil.EnsureRepo(ctx, p); err != nil {
@@ -94,39 +228,27 @@ func (r *Runner) Run(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("repo %q: %w", rSpec.Name, err)
 		}
-		clean, err := gitutil.IsClean(ctx, root)
+		origBranch, err := gitutil.HeadRef(ctx, root)
 		if err != nil {
 			return fmt.Errorf("repo %q: %w", rSpec.Name, err)
 		}
-		if !clean {
-			dirty, _ := gitutil.DirtyFiles(ctx, root)
-			if r.Opts.DryRun || !isInteractive() {
-				return fmt.Errorf("repo %q: git working tree is dirty:\n%s", rSpec.Name, strings.TrimSpace(dirty))
-			}
-			fmt.Printf("repo %q has uncommitted changes:\n%s\n", rSpec.Name, strings.TrimSpace(dirty))
-			fmt.Printf("Stash and continue? [y/N] ")
-			scanner := bufio.NewScanner(os.Stdin)
-			scanner.Scan()
-			ans := strings.TrimSpace(strings.ToLower(scanner.Text()))
-			if ans != "y" && ans != "yes" {
-				return fmt.Errorf("repo %q: aborted due to dirty working tree", rSpec.Name)
-			}
-			if err := gitutil.Stash(ctx, root, "devspec -- auto-stash before run"); err != nil {
-				return fmt.Errorf("repo %q: stash failed: %w", rSpec.Name, err)
-			}
-			stashedRepos = append(stashedRepos, rSpec.Name)
-			fmt.Printf("stashed changes in %s\n", rSpec.Name)
+		origHead, err := gitutil.RevParse(ctx, root, "HEAD")
+		if err != nil {
+			return fmt.Errorf("repo %q: %w", rSpec.Name, err)
 		}
 		st.repos = append(st.repos, repoState{
-			spec: rSpec,
-			path: root,
+			spec:       rSpec,
+			path:       root,
+			origBranch: origBranch,
+			origHead:   origHead,
 		})
 	}
+	return nil
+}
 
-	if err := r.loadContent(st); err != nil {
-		return err
-	}
-
+// gatherContext collects the context configured in context: the repo tree,
+// files, git history, symbols and diff.
+func (r *Runner) gatherContext(ctx context.Context, st *runState) error {
 	if r.Spec.Context.IncludeRepoTree {
 		var trees []string
 		for _, rs := range st.repos {
@@ -138,6 +260,33 @@ func (r *Runner) Run(ctx context.Context) error {
 		}
 		st.repoTree = strings.Join(trees, "\n\n")
 	}
+	if err := r.loadContextFiles(ctx, st); err != nil {
+		return err
+	}
+	if err := r.loadGitLog(ctx, st); err != nil {
+		return err
+	}
+	if lang := r.Spec.Context.Symbols; lang != "" {
+		provider, err := symbols.Lookup(lang)
+		if err != nil {
+			return err
+		}
+		var outlines []string
+		for _, rs := range st.repos {
+			files, err := gitutil.ListFiles(ctx, rs.path)
+			if err != nil {
+				return err
+			}
+			outline, err := provider.Outline(rs.path, files)
+			if err != nil {
+				return fmt.Errorf("repo %q symbols: %w", rs.spec.Name, err)
+			}
+			if outline != "" {
+				outlines = append(outlines, fmt.Sprintf("==> %s:\n%s", rs.spec.Name, outline))
+			}
+		}
+		st.symbols = strings.Join(outlines, "\n\n")
+	}
 	if r.Spec.Context.IncludeGitDiff {
 		var diffs []string
 		for _, rs := range st.repos {
@@ -151,70 +300,157 @@ func (r *Runner) Run(ctx context.Context) error {
 		}
 		st.gitDiff = strings.Join(diffs, "\n\n")
 	}
+	return nil
+}
 
-	if err := r.setupWorkspace(ctx, st); err != nil {
-		return err
-	}
-	defer func() {
-		if st.workspaceFile != "" && !r.Opts.KeepWorkspace {
-			os.RemoveAll(st.workspaceFile)
+func (r *Runner) loadContent(st *runState) error {
+	for name, ag := range r.Spec.Agents {
+		promptBody, err := r.resolveContent(ag.Prompt)
+		if err != nil {
+			return fmt.Errorf("resolve agent %q prompt: %w", name, err)
 		}
-	}()
+		st.agentPrompts[name] = promptBody
+	}
 
-	for i, step := range r.Spec.Steps {
-		stepNum := fmt.Sprintf("[%d/%d]", i+1, len(r.Spec.Steps))
-		if strings.TrimSpace(step.Run) != "" {
-			fmt.Printf("\n==> %s %s (shell)\n", stepNum, step.Name)
-		} else {
-			model := r.Spec.EffectiveAgentModel(step.Agent, r.Opts.ModelOverride)
-			mode := step.Mode
-			if mode == "" {
-				mode = "agent"
-			}
-			fmt.Printf("\n==> %s %s (agent: %s, model: %s, mode: %s)\n", stepNum, step.Name, step.Agent, model, mode)
+	st.promptTemplates = make(map[string]string)
+	paths := make([]string, 0, len(r.Spec.Prompts))
+	for _, path := range r.Spec.Prompts {
+		paths = append(paths, path)
+	}
+	for _, step := range r.Spec.Steps {
+		if step.PromptTemplate != "" {
+			paths = append(paths, step.PromptTemplate)
 		}
-		start := time.Now()
-		if err := r.runStep(ctx, st, step); err != nil {
-			return err
+	}
+	for _, path := range paths {
+		if _, ok := st.promptTemplates[path]; ok {
+			continue
 		}
-		elapsed := time.Since(start)
-		fmt.Printf("  done in %.1fs\n", elapsed.Seconds())
+		b, err := os.ReadFile(r.Spec.ResolvePath(path))
+		if err != nil {
+			return fmt.Errorf("read prompt template: %w", err)
+		}
+		if _, err := prompt.Parse(path, string(b)); err != nil {
+			return fmt.Errorf("prompt template %s: %w", path, err)
+		}
+		st.promptTemplates[path] = string(b)
 	}
 
-	if err := r.finalize(ctx, st); err != nil {
+	if err := r.loadRules(st); err != nil {
 		return err
 	}
 
-	fmt.Printf("devspec completed successfully on branch %s\n", st.branchName)
-	if len(stashedRepos) > 0 {
-		fmt.Println()
-		for _, name := range stashedRepos {
-			fmt.Printf("note: repo %q was stashed before run. Run 'git stash pop' in %s to restore.\n", name, name)
-		}
+	return r.loadSkills(st)
+}
+
+// renderPrompt renders the prompt of the given kind from the step's
+// prompt_template, else prompts.<kind>, else the embedded default.
+func (r *Runner) renderPrompt(st *runState, kind string, step *spec.Step, in prompt.Inputs) (string, error) {
+	return prompt.Render(kind, r.promptTemplate(st, kind, step), r.promptInputs(st, kind, in))
+}
+
+// promptTemplate returns the template override for a prompt, or "" for the
+// embedded default.
+func (r *Runner) promptTemplate(st *runState, kind string, step *spec.Step) string {
+	path := r.Spec.Prompts[kind]
+	if step != nil && step.PromptTemplate != "" {
+		path = step.PromptTemplate
 	}
-	return nil
+	return st.promptTemplates[path]
 }
 
-func (r *Runner) loadContent(st *runState) error {
-	for name, ag := range r.Spec.Agents {
-		promptBody, err := r.resolveContent(ag.Prompt)
-		if err != nil {
-			return fmt.Errorf("resolve agent %q prompt: %w", name, err)
-		}
-		st.agentPrompts[name] = promptBody
+// promptInputs completes in with the workspace repos and fits its context
+// into context.max_tokens.
+func (r *Runner) promptInputs(st *runState, kind string, in prompt.Inputs) prompt.Inputs {
+	if in.Repos == nil {
+		in.Repos = promptRepos(st.repos)
+	}
+	if r.Spec.Context.MaxTokens > 0 {
+		in = r.packContext(st, kind, in)
 	}
+	return in
+}
 
-	if len(r.Spec.Skills) > 0 {
-		for _, skill := range r.Spec.Skills {
-			skillBody, err := r.resolveContent(skill)
-			if err != nil {
-				return fmt.Errorf("resolve skill: %w", err)
-			}
-			st.skillBodies = append(st.skillBodies, skillBody)
+func promptRepos(repos []repoState) []prompt.Repo {
+	out := make([]prompt.Repo, 0, len(repos))
+	for _, rs := range repos {
+		out = append(out, prompt.Repo{Name: rs.spec.Name, Root: rs.path, Description: strings.TrimSpace(rs.spec.Description)})
+	}
+	return out
+}
+
+// packContext trims the skills, files, repo tree, symbols, git history and
+// diff of in to fit in context.max_tokens and reports what it cut.
+func (r *Runner) packContext(st *runState, kind string, in prompt.Inputs) prompt.Inputs {
+	sections := repocontext.Sections{
+		Tree:    in.RepoTree,
+		Symbols: in.Symbols,
+		Diff:    in.GitDiff,
+		GitLog:  in.GitLog,
+		History: in.FileHistory,
+	}
+	if in.DiffOutput != "" {
+		sections.Diff = in.DiffOutput
+	}
+	for _, body := range in.Skills {
+		sections.Skills = append(sections.Skills, repocontext.Skill{Name: skillNamed(st, body), Body: body})
+	}
+	for _, body := range in.Files {
+		name, _, _ := strings.Cut(strings.TrimPrefix(body, "==> "), "\n")
+		sections.Files = append(sections.Files, repocontext.File{Name: name, Body: body})
+	}
+	packed, report := repocontext.Pack(r.Spec.Context.MaxTokens, sections)
+	if len(report.Trimmed) == 0 {
+		return in
+	}
+	st.logf("  %s prompt: %s\n", kind, report)
+
+	in.RepoTree = packed.Tree
+	in.Symbols = packed.Symbols
+	in.GitLog = packed.GitLog
+	in.FileHistory = packed.History
+	if in.DiffOutput != "" {
+		in.DiffOutput = packed.Diff
+	} else {
+		in.GitDiff = packed.Diff
+	}
+	in.Skills = nil
+	for _, sk := range packed.Skills {
+		in.Skills = append(in.Skills, sk.Body)
+	}
+	in.Files = fileBodies(packed.Files)
+	return in
+}
+
+// taskTitle is the title of the task: the issue title, or else the first
+// line of the task.
+func (r *Runner) taskTitle() string {
+	if t := strings.TrimSpace(r.Opts.Title); t != "" {
+		return t
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(r.Opts.Task), "\n")
+	return strings.TrimSpace(line)
+}
+
+// skillName names the i-th skill in reports: its spec entry, or its
+// position if that spans several lines.
+func (r *Runner) skillName(i int) string {
+	if i < len(r.Spec.Skills) {
+		if raw := strings.TrimSpace(r.Spec.Skills[i]); raw != "" && !strings.Contains(raw, "\n") {
+			return raw
 		}
 	}
+	return fmt.Sprintf("#%d", i+1)
+}
 
-	return nil
+// skillNamed returns the name of the loaded skill with the given body.
+func skillNamed(st *runState, body string) string {
+	for _, sk := range st.skills {
+		if sk.Body == body {
+			return sk.Name
+		}
+	}
+	return "skill"
 }
 
 func (r *Runner) resolveContent(raw string) (string, error) {
@@ -238,21 +474,47 @@ func (r *Runner) resolveContent(raw string) (string, error) {
 }
 
 func (r *Runner) setupWorkspace(ctx context.Context, st *runState) error {
-	st.branchName = makeBranchName(r.Spec.Workspace.BranchPref, r.Spec.Name, r.Now())
+	if r.Opts.Branch != "" {
+		st.branchName = r.Opts.Branch
+	} else {
+		name, err := r.newBranchName(ctx, st)
+		if err != nil {
+			return err
+		}
+		st.branchName = name
+	}
 
 	if !r.Opts.DryRun {
-		for _, rs := range st.repos {
-			if err := gitutil.Checkout(ctx, rs.path, rs.spec.BaseBranch); err != nil {
-				return fmt.Errorf("repo %q checkout: %w", rs.spec.Name, err)
+		var continued []string
+		for i := range st.repos {
+			rs := &st.repos[i]
+			rs.touched = true
+			carried, err := stashCarried(ctx, rs)
+			if err != nil {
+				return err
+			}
+			ok, err := r.checkoutAgentBranch(ctx, rs, st.branchName)
+			if err != nil {
+				if carried != "" {
+					err = fmt.Errorf("%w (uncommitted changes are stashed as %s)", err, carried)
+				}
+				return err
 			}
-			if err := gitutil.PullFFOnly(ctx, rs.path, rs.spec.BaseBranch); err != nil {
-				return fmt.Errorf("repo %q pull: %w", rs.spec.Name, err)
+			if ok {
+				continued = append(continued, rs.spec.Name)
 			}
-			if err := gitutil.CreateBranch(ctx, rs.path, st.branchName); err != nil {
-				return fmt.Errorf("repo %q fork: %w", rs.spec.Name, err)
+			if carried != "" {
+				if err := commitCarried(ctx, rs, carried); err != nil {
+					return err
+				}
 			}
 		}
-		fmt.Printf("created branch: %s\n", st.branchName)
+		if len(continued) > 0 {
+			fmt.Printf("continuing branch: %s (%s)\n", st.branchName, strings.Join(continued, ", "))
+		}
+		if len(continued) < len(st.repos) {
+			fmt.Printf("created branch: %s\n", st.branchName)
+		}
 	} else {
 		fmt.Printf("dry-run enabled: workspace mutations skipped\n")
 	}
@@ -264,10 +526,49 @@ func (r *Runner) setupWorkspace(ctx context.Context, st *runState) error {
 	return nil
 }
 
+// checkoutAgentBranch continues the agent branch in rs with --branch, or
+// else forks it from the up-to-date base branch. It reports whether the
+// branch was continued.
+func (r *Runner) checkoutAgentBranch(ctx context.Context, rs *repoState, branch string) (bool, error) {
+	if r.Opts.Branch != "" {
+		ok, err := continueBranch(ctx, rs, branch)
+		if err != nil {
+			return false, fmt.Errorf("repo %q continue %s: %w", rs.spec.Name, branch, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	// Worktrees are already detached at the base branch, which may
+	// still be checked out in the original tree.
+	if rs.worktreeOf == "" {
+		if err := gitutil.Checkout(ctx, rs.path, rs.spec.BaseBranch); err != nil {
+			return false, fmt.Errorf("repo %q checkout: %w", rs.spec.Name, err)
+		}
+	}
+	if err := pullBase(ctx, rs); err != nil {
+		return false, fmt.Errorf("repo %q pull: %w", rs.spec.Name, err)
+	}
+	if err := gitutil.CreateBranch(ctx, rs.path, branch); err != nil {
+		return false, fmt.Errorf("repo %q fork: %w", rs.spec.Name, err)
+	}
+	rs.createdBranch = true
+	base, err := gitutil.RevParse(ctx, rs.path, "HEAD")
+	if err != nil {
+		return false, fmt.Errorf("repo %q: %w", rs.spec.Name, err)
+	}
+	rs.baseRef = base
+	return false, nil
+}
+
 func (r *Runner) writeWorkspaceFile(st *runState) error {
 	if len(st.repos) == 1 {
 		// Single repo: no need for a workspace file, we will just pass the path.
-		// For Cursor, we can just omit the workspace file and it opens the dir.
+		// For Cursor, we can just omit the workspace file and it opens the dir,
+		// unless the run happens in a separate worktree.
+		if st.repos[0].worktreeOf != "" {
+			st.workspacePath = st.repos[0].path
+		}
 		return nil
 	}
 
@@ -303,10 +604,11 @@ func (r *Runner) writeWorkspaceFile(st *runState) error {
 	// The cursor CLI expects the workspace flag to be the DIRECTORY containing the .code-workspace file
 	// or just the directory if there is no workspace file.
 	st.workspaceFile = tmpDir
+	st.workspacePath = tmpDir
 	return nil
 }
 
-func (r *Runner) runStep(ctx context.Context, st *runState, step spec.Step) error {
+func (r *Runner) runStep(ctx context.Context, st *runState, step spec.Step, res *stepResult) error {
 	if r.Opts.DryRun {
 		if strings.TrimSpace(step.Run) != "" {
 			fmt.Printf("dry-run: would run shell step %s\n", step.Name)
@@ -316,24 +618,20 @@ func (r *Runner) runStep(ctx context.Context, st *runState, step spec.Step) erro
 		return nil
 	}
 	if strings.TrimSpace(step.Run) != "" {
-		return r.runCommandStep(ctx, step)
+		return r.runCommandStep(ctx, st, step, res)
 	}
 	return r.runAgentStep(ctx, st, step)
 }
 
 func (r *Runner) runAgentStep(ctx context.Context, st *runState, step spec.Step) error {
-	agPrompt, ok := st.agentPrompts[step.Agent]
-	if !ok {
-		return fmt.Errorf("prompt for agent %q not loaded", step.Agent)
-	}
 	mode := strings.TrimSpace(step.Mode)
 	if mode == "agent" {
 		mode = ""
 	}
 	model := r.Spec.EffectiveAgentModel(step.Agent, r.Opts.ModelOverride)
+	kind := stepKind(step)
 
-	switch {
-	case mode == "plan":
+	if kind == prompt.KindPlan {
 		for i := range st.repos {
 			before, err := gitutil.ChangedFiles(ctx, st.repos[i].path)
 			if err != nil {
@@ -341,15 +639,22 @@ func (r *Runner) runAgentStep(ctx context.Context, st *runState, step spec.Step)
 			}
 			st.repos[i].beforeDiff = before
 		}
+	} else if err := r.bumpIteration(st); err != nil {
+		return err
+	}
 
-		out, err := r.Orchestrator.Run(ctx, prompt.BuildPlan(prompt.Inputs{
-			Spec:          r.Spec,
-			Task:          r.Opts.Task,
-			PlannerPrompt: agPrompt,
-			Skills:        st.skillBodies,
-			RepoTree:      st.repoTree,
-			GitDiff:       st.gitDiff,
-		}), orchestrator.RunConfig{Model: model, Mode: "plan", WorkspacePath: st.workspaceFile})
+	in, err := r.stepInputs(ctx, st, step)
+	if err != nil {
+		return err
+	}
+	p, err := r.renderPrompt(st, kind, &step, in)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case prompt.KindPlan:
+		out, err := r.Orchestrator.Run(ctx, p, orchestrator.RunConfig{Model: model, Mode: "plan", WorkspacePath: st.workspacePath})
 		if err != nil {
 			return err
 		}
@@ -364,30 +669,9 @@ func (r *Runner) runAgentStep(ctx context.Context, st *runState, step spec.Step)
 				return fmt.Errorf("plan phase modified files in repo %q, which is not allowed", rs.spec.Name)
 			}
 		}
-		return nil
-	case strings.EqualFold(step.Name, "self_review"):
-		if err := r.bumpIteration(st); err != nil {
-			return err
-		}
-
-		var currentDiffs []string
-		for _, rs := range st.repos {
-			d, err := gitutil.Diff(ctx, rs.path)
-			if err != nil {
-				return err
-			}
-			if d != "" {
-				currentDiffs = append(currentDiffs, fmt.Sprintf("==> %s:\n%s", rs.spec.Name, d))
-			}
-		}
-
-		_, err := r.Orchestrator.Run(ctx, prompt.BuildSelfReview(prompt.Inputs{
-			Spec:       r.Spec,
-			ImplPrompt: agPrompt,
-			Skills:     st.skillBodies,
-			DiffOutput: strings.Join(currentDiffs, "\n\n"),
-		}), orchestrator.RunConfig{Model: model, Mode: mode, WorkspacePath: st.workspaceFile})
-		if err != nil {
+		return r.addPlanContext(ctx, st)
+	case prompt.KindReview:
+		if _, err := r.Orchestrator.Run(ctx, p, orchestrator.RunConfig{Model: model, Mode: mode, WorkspacePath: st.workspacePath}); err != nil {
 			return err
 		}
 		if err := r.validateMutation(ctx, st, false); err != nil {
@@ -395,19 +679,7 @@ func (r *Runner) runAgentStep(ctx context.Context, st *runState, step spec.Step)
 		}
 		return nil
 	default:
-		if err := r.bumpIteration(st); err != nil {
-			return err
-		}
-		_, err := r.Orchestrator.Run(ctx, prompt.BuildImplement(prompt.Inputs{
-			Spec:       r.Spec,
-			Task:       r.Opts.Task,
-			ImplPrompt: agPrompt,
-			Skills:     st.skillBodies,
-			RepoTree:   st.repoTree,
-			GitDiff:    st.gitDiff,
-			PlanOutput: st.planOutput,
-		}), orchestrator.RunConfig{Model: model, Mode: mode, WorkspacePath: st.workspaceFile})
-		if err != nil {
+		if _, err := r.Orchestrator.Run(ctx, p, orchestrator.RunConfig{Model: model, Mode: mode, WorkspacePath: st.workspacePath}); err != nil {
 			return err
 		}
 		// Keep existing behavior: require a non-empty diff on the first implement step.
@@ -419,7 +691,89 @@ func (r *Runner) runAgentStep(ctx context.Context, st *runState, step spec.Step)
 	}
 }
 
-func (r *Runner) runCommandStep(ctx context.Context, step spec.Step) error {
+// stepKind returns the prompt kind of an agent step.
+func stepKind(step spec.Step) string {
+	switch {
+	case strings.TrimSpace(step.Mode) == "plan":
+		return prompt.KindPlan
+	case strings.EqualFold(step.Name, "self_review"):
+		return prompt.KindReview
+	}
+	return prompt.KindImplement
+}
+
+// stepInputs gathers what the prompt of an agent step is rendered with.
+func (r *Runner) stepInputs(ctx context.Context, st *runState, step spec.Step) (prompt.Inputs, error) {
+	agPrompt, ok := st.agentPrompts[step.Agent]
+	if !ok {
+		return prompt.Inputs{}, fmt.Errorf("prompt for agent %q not loaded", step.Agent)
+	}
+	kind := stepKind(step)
+	skills, err := r.stepSkills(ctx, st, step.Name, kind)
+	if err != nil {
+		return prompt.Inputs{}, err
+	}
+	files, err := r.contextBodies(st)
+	if err != nil {
+		return prompt.Inputs{}, err
+	}
+	in := prompt.Inputs{
+		Spec:       r.Spec,
+		Task:       r.Opts.Task,
+		Acceptance: r.Opts.Acceptance,
+		Skills:     skills,
+		Feedback:   r.Opts.Feedback,
+	}
+	switch kind {
+	case prompt.KindPlan:
+		in.PlannerPrompt = agPrompt
+		in.RepoTree = st.repoTree
+		in.Files = files
+		in.Symbols = st.symbols
+		in.GitLog = st.gitLog
+		in.GitDiff = st.gitDiff
+	case prompt.KindReview:
+		var currentDiffs []string
+		for _, rs := range st.repos {
+			d, err := repoDiff(ctx, rs)
+			if err != nil {
+				return in, err
+			}
+			if d != "" {
+				currentDiffs = append(currentDiffs, fmt.Sprintf("==> %s:\n%s", rs.spec.Name, d))
+			}
+		}
+		in.ImplPrompt = agPrompt
+		in.PlanOutput = st.planOutput
+		in.DiffOutput = strings.Join(currentDiffs, "\n\n")
+	default:
+		in.ImplPrompt = agPrompt
+		in.RepoTree = st.repoTree
+		in.Files = files
+		in.Symbols = st.symbols
+		in.GitLog = st.gitLog
+		in.FileHistory = st.fileHistory
+		in.GitDiff = st.gitDiff
+		in.PlanOutput = st.planOutput
+	}
+	return in, nil
+}
+
+// addPlanContext adds the context that depends on the plan: the files it
+// names and their history.
+func (r *Runner) addPlanContext(ctx context.Context, st *runState) error {
+	if r.Spec.Context.IncludePlanFiles {
+		if err := r.addPlanFiles(ctx, st); err != nil {
+			return err
+		}
+	}
+	if r.Spec.Context.GitLog.PlanFiles {
+		return r.addFileHistory(ctx, st)
+	}
+	return nil
+}
+
+func (r *Runner) runCommandStep(ctx context.Context, st *runState, step spec.Step, res *stepResult) error {
 	cmdStr := step.Run
 	if len(cmdStr) > 60 {
 		cmdStr = cmdStr[:57] + "..."
@@ -427,9 +781,11 @@ func (r *Runner) runCommandStep(ctx context.Context, step spec.Step) error {
 	sp := newSpinner(fmt.Sprintf("running: %s", cmdStr))
 	sp.Start()
 	cmd := exec.CommandContext(ctx, "sh", "-c", step.Run)
-	cmd.Dir = r.Workdir
+	cmd.Dir = commandDir(r.Workdir, st.repos)
 	out, err := cmd.CombinedOutput()
+	res.Output = tailOutput(string(out))
 	if err != nil {
+		res.Passed = false
 		sp.Stop(fmt.Sprintf("  ✗ %s failed", step.Name))
 		if step.AllowFailure {
 			fmt.Printf("  (allow_failure=true, continuing)\n")
@@ -456,18 +812,37 @@ func (r *Runner) bumpIteration(st *runState) error {
 }
 
 func (r *Runner) validateMutation(ctx context.Context, st *runState, requireDiff bool) error {
-	var totalLines int
-	var anyFiles bool
-	var hasTests bool
+	totalLines, anyFiles, hasTests, err := diffTotals(ctx, st)
+	if err != nil {
+		return err
+	}
+
+	if requireDiff && !anyFiles {
+		return errors.New("phase produced no diff")
+	}
+
+	if totalLines > r.Spec.Constraints.MaxDiffLines {
+		return fmt.Errorf("diff line limit exceeded (%d > %d)", totalLines, r.Spec.Constraints.MaxDiffLines)
+	}
+
+	if r.Spec.Constraints.RequireTests && anyFiles && !hasTests {
+		return errors.New("constraints.require_tests is true but no test files were modified")
+	}
+
+	return nil
+}
 
+// diffTotals measures the run's changes across all repos: changed diff
+// lines, whether any file changed, and whether any test file changed.
+func diffTotals(ctx context.Context, st *runState) (lines int, anyFiles, hasTests bool, err error) {
 	for _, rs := range st.repos {
-		d, err := gitutil.Diff(ctx, rs.path)
+		d, err := repoDiff(ctx, rs)
 		if err != nil {
-			return err
+			return 0, false, false, err
 		}
-		files, err := gitutil.ChangedFiles(ctx, rs.path)
+		files, err := repoChangedFiles(ctx, rs)
 		if err != nil {
-			return err
+			return 0, false, false, err
 		}
 
 		if len(files) > 0 {
@@ -476,32 +851,48 @@ func (r *Runner) validateMutation(ctx context.Context, st *runState, requireDiff
 				hasTests = true
 			}
 		}
-		totalLines += gitutil.DiffLineCount(d)
+		lines += gitutil.DiffLineCount(d)
 	}
+	return lines, anyFiles, hasTests, nil
+}
 
-	if requireDiff && !anyFiles {
-		return errors.New("phase produced no diff")
+func (r *Runner) finalize(ctx context.Context, st *runState) error {
+	if r.Opts.DryRun {
+		return nil
 	}
 
-	if totalLines > r.Spec.Constraints.MaxDiffLines {
-		return fmt.Errorf("diff line limit exceeded (%d > %d)", totalLines, r.Spec.Constraints.MaxDiffLines)
+	if r.Spec.Workspace.AutoCommit {
+		for i := range st.repos {
+			rs := &st.repos[i]
+			committed, err := r.commitRepo(ctx, st, rs, nil)
+			if err != nil {
+				return err
+			}
+			if committed {
+				fmt.Printf("changes committed in %s\n", rs.spec.Name)
+			}
+		}
 	}
 
-	if r.Spec.Constraints.RequireTests && anyFiles && !hasTests {
-		return errors.New("constraints.require_tests is true but no test files were modified")
+	// Rebase every repo before pushing any of them, so a conflict in one
+	// repo doesn't leave the others half published.
+	if err := r.rebaseRepos(ctx, st); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func (r *Runner) finalize(ctx context.Context, st *runState) error {
-	if r.Opts.DryRun {
-		return nil
+	var report prData
+	if r.Spec.Output.CreatePR && !r.Opts.NoPR {
+		var err error
+		if report, err = r.prReport(ctx, st); err != nil {
+			return err
+		}
 	}
 
 	var anyChanges bool
-	for _, rs := range st.repos {
-		files, err := gitutil.ChangedFiles(ctx, rs.path)
+	var prs []pullRequest
+	for i := range st.repos {
+		rs := &st.repos[i]
+		files, err := repoChangedFiles(ctx, *rs)
 		if err != nil {
 			return err
 		}
@@ -510,24 +901,20 @@ func (r *Runner) finalize(ctx context.Context, st *runState) error {
 		}
 		anyChanges = true
 
-		if r.Spec.Workspace.AutoCommit {
-			if err := gitutil.AddAll(ctx, rs.path); err != nil {
-				return err
-			}
-			msg := fmt.Sprintf("devspec: %s", r.Spec.Name)
-			if err := gitutil.Commit(ctx, rs.path, msg); err != nil {
-				return err
-			}
-			fmt.Printf("changes committed in %s\n", rs.spec.Name)
-		}
-
 		if r.Spec.Output.CreatePR && !r.Opts.NoPR {
-			if err := gitutil.Push(ctx, rs.path, st.branchName); err != nil {
+			push := gitutil.Push
+			if rs.continued && rs.rebased {
+				push = gitutil.ForcePush
+			}
+			if err := push(ctx, rs.path, rs.spec.PushRemote, st.branchName); err != nil {
 				return err
 			}
-			if err := createPR(ctx, rs.path, r.Spec, st.branchName, r.Opts.Task); err != nil {
+			rs.pushed = true
+			pr, err := r.openPR(ctx, st, rs, report)
+			if err != nil {
 				return err
 			}
+			prs = append(prs, pr)
 		}
 	}
 
@@ -535,30 +922,61 @@ func (r *Runner) finalize(ctx context.Context, st *runState) error {
 		return errors.New("no changes generated across any repo")
 	}
 
-	return nil
+	return r.linkPRs(ctx, st, prs)
 }
 
-func createPR(ctx context.Context, repoRoot string, s *spec.Spec, branch, task string) error {
-	bodyPath := s.ResolvePath(s.Output.PRTemplate)
-	if _, err := os.Stat(bodyPath); err != nil {
-		return fmt.Errorf("pr template not found: %w", err)
+// continueBranch checks out an existing agent branch, from the local repo or
+// the push remote, and brings it up to date. It reports false if the branch
+// exists in neither.
+func continueBranch(ctx context.Context, rs *repoState, branch string) (bool, error) {
+	local, err := gitutil.BranchExists(ctx, rs.path, branch)
+	if err != nil {
+		return false, err
 	}
-
-	title := fmt.Sprintf("devspec: %s", task)
-	cmd := exec.CommandContext(ctx, "gh", "pr", "create",
-		"--base", s.Workspace.BaseBranch,
-		"--head", branch,
-		"--title", title,
-		"--body-file", bodyPath,
-	)
-	cmd.Dir = repoRoot
-	out, err := cmd.CombinedOutput()
+	if local {
+		if err := gitutil.Checkout(ctx, rs.path, branch); err != nil {
+			return false, err
+		}
+		err := gitutil.PullFFOnly(ctx, rs.path, rs.spec.PushRemote, branch)
+		if err != nil && !errors.Is(err, gitutil.ErrRemoteRefNotFound) {
+			return false, err
+		}
+	} else {
+		remote, err := gitutil.RemoteBranchExists(ctx, rs.path, rs.spec.PushRemote, branch)
+		if err != nil || !remote {
+			return false, err
+		}
+		if err := gitutil.CheckoutRemote(ctx, rs.path, rs.spec.PushRemote, branch); err != nil {
+			return false, err
+		}
+	}
+	head, err := gitutil.RevParse(ctx, rs.path, "HEAD")
 	if err != nil {
-		return fmt.Errorf("gh pr create failed: %w\n%s", err, strings.TrimSpace(string(out)))
+		return false, err
 	}
-	prURL := strings.TrimSpace(string(out))
-	fmt.Printf("pull request created: %s\n", prURL)
-	return nil
+	rs.baseRef = head
+	rs.branchHead = head
+	rs.continued = true
+	return true, nil
+}
+
+// pullBase fast-forwards the base branch from the repo's remote. Branches
+// that only exist locally, and repos without the remote, are left as they
+// are.
+func pullBase(ctx context.Context, rs *repoState) error {
+	if ok, err := gitutil.HasRemote(ctx, rs.path, rs.spec.Remote); err != nil || !ok {
+		return err
+	}
+	err := gitutil.PullFFOnly(ctx, rs.path, rs.spec.Remote, rs.spec.BaseBranch)
+	switch {
+	case err == nil, errors.Is(err, gitutil.ErrRemoteRefNotFound), errors.Is(err, gitutil.ErrNoTrackingInfo):
+		return nil
+	case errors.Is(err, gitutil.ErrDiverged):
+		return fmt.Errorf("%w; please rebase or reset your local %s", err, rs.spec.BaseBranch)
+	case errors.Is(err, gitutil.ErrLocalChanges):
+		return fmt.Errorf("%w; please stash or commit them", err)
+	}
+	return err
 }
 
 func makeBranchName(prefix, name string, ts time.Time) string {
@@ -571,16 +989,54 @@ func makeBranchName(prefix, name string, ts time.Time) string {
 }
 
 func sanitizeName(s string) string {
-	s = strings.ToLower(strings.TrimSpace(s))
-	re := regexp.MustCompile(`[^a-z0-9._-]+`)
-	s = re.ReplaceAllString(s, "-")
-	s = strings.Trim(s, "-./")
-	if s == "" {
+	if s = sanitizeSegment(s); s == "" {
 		return "run"
 	}
 	return s
 }
 
+var unsafeRefChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// sanitizeSegment reduces s to characters that are safe in a single path
+// segment of a branch name. It may return "".
+func sanitizeSegment(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = unsafeRefChars.ReplaceAllString(s, "-")
+	return strings.Trim(s, "-./")
+}
+
+// repoDiff returns everything the run changed in rs so far, including
+// changes already committed by commit.per_step.
+func repoDiff(ctx context.Context, rs repoState) (string, error) {
+	if rs.baseRef == "" {
+		return gitutil.Diff(ctx, rs.path)
+	}
+	return gitutil.DiffSince(ctx, rs.path, rs.baseRef)
+}
+
+func repoChangedFiles(ctx context.Context, rs repoState) ([]string, error) {
+	if rs.baseRef == "" {
+		return gitutil.ChangedFiles(ctx, rs.path)
+	}
+	return gitutil.ChangedFilesSince(ctx, rs.path, rs.baseRef)
+}
+
+// commandDir maps dir into the worktree of the repo containing it, so shell
+// steps see the same tree the agent edits.
+func commandDir(dir string, repos []repoState) string {
+	for _, rs := range repos {
+		if rs.worktreeOf == "" {
+			continue
+		}
+		rel, err := filepath.Rel(rs.worktreeOf, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return filepath.Join(rs.path, rel)
+	}
+	return dir
+}
+
 func hasTestFile(files []string) bool {
 	for _, f := range files {
 		if testFilePattern.MatchString(filepath.ToSlash(f)) {
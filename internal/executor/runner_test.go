@@ -1,8 +1,19 @@
 package executor
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/threatlevelmidnight10/devspec/internal/forge"
+	"github.com/threatlevelmidnight10/devspec/internal/prompt"
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
 )
 
 func TestMakeBranchName(t *testing.T) {
@@ -13,3 +24,742 @@ func TestMakeBranchName(t *testing.T) {
 		t.Fatalf("want %q, got %q", want, got)
 	}
 }
+
+func TestParseDirtyStrategy(t *testing.T) {
+	got, err := ParseDirtyStrategy("Stash")
+	if err != nil || got != DirtyStash {
+		t.Fatalf("want %q, got %q (err %v)", DirtyStash, got, err)
+	}
+	if _, err := ParseDirtyStrategy("discard"); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+}
+
+func TestCommitMessageAppendsTrailers(t *testing.T) {
+	r := Runner{
+		Spec: &spec.Spec{
+			Name:       "migrate",
+			Model:      "base-model",
+			SourceHash: "0123456789abcdef",
+			Agents:     map[string]spec.Agent{"impl": {Model: "impl-model"}},
+			Commit:     spec.Commit{Message: "chore({{.Repo}}): {{.Task}}"},
+		},
+		Opts: Options{Task: "add column"},
+	}
+	st := &runState{runID: "run-1"}
+	rs := &repoState{spec: spec.RepoSpec{Name: "api"}}
+
+	got, err := r.commitMessage(context.Background(), st, rs, &spec.Step{Name: "implement", Agent: "impl"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "chore(api): add column\n\n" +
+		"Devspec-Run: run-1\n" +
+		"Devspec-Step: implement\n" +
+		"Devspec-Model: impl-model\n" +
+		"Devspec-Spec-Hash: 0123456789ab"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestCommitStepCommitsNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("config", "user.name", "t")
+	git("config", "user.email", "t@example.com")
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	if err := os.MkdirAll(filepath.Join(dir, "db"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "db", "0043_orders.sql"), []byte("create table orders ();\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runner{Spec: &spec.Spec{Name: "migrate", Commit: spec.Commit{PerStep: true}}}
+	st := &runState{runID: "run-1", repos: []repoState{{spec: spec.RepoSpec{Name: "api"}, path: dir}}}
+	if err := r.commitStep(context.Background(), st, spec.Step{Name: "implement", Agent: "coder"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := git("show", "--name-only", "--format=%s", "HEAD"); got != "devspec: migrate (implement)\n\ndb/0043_orders.sql" {
+		t.Errorf("HEAD: %q", got)
+	}
+}
+
+func TestCheckCommitMessage(t *testing.T) {
+	msg := cleanAgentMessage("```\nfeat(api): add pagination\n\nAdds limit and offset.\n```")
+	if err := checkCommitMessage(msg, spec.DefaultCommitPattern); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := checkCommitMessage("Added pagination", spec.DefaultCommitPattern); err == nil {
+		t.Fatal("expected error for non-conventional subject")
+	}
+}
+
+func TestRenderBranchName(t *testing.T) {
+	data := branchData{
+		Prefix:    "agent",
+		TaskSlug:  slug("Fix PROJ-42: crash when saving an empty profile picture", 30),
+		Ticket:    "PROJ-42",
+		User:      "Jane Doe",
+		Timestamp: "20260220-150000",
+	}
+	got, err := renderBranchName("{{.Prefix}}/{{.User}}/{{.Ticket}}-{{.TaskSlug}}", data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "agent/jane-doe/proj-42-fix-proj-42-crash-when-saving"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	got, err = renderBranchName("{{.Prefix}}/{{.Ticket}}/{{.Timestamp}}", branchData{Prefix: "agent", Timestamp: "20260220-150000"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "agent/20260220-150000"; got != want {
+		t.Fatalf("empty segments should be dropped: want %q, got %q", want, got)
+	}
+}
+
+func TestRenderPRBodyWithExampleTemplate(t *testing.T) {
+	r := Runner{Spec: &spec.Spec{
+		SourceDir: "../../examples",
+		Output:    spec.Output{PRTemplate: "templates/pr.md"},
+	}}
+	data := prData{
+		Spec:       "migrate",
+		Task:       "add column",
+		Acceptance: []string{"old rows keep working"},
+		Plan:       "1. add migration",
+		Model:      "base-model",
+		RunID:      "run-1",
+		Repos:      []prRepo{{Name: "api", BaseBranch: "main", DiffStat: " db.go | 2 +-"}},
+		Checks:     []stepResult{{Name: "test", Kind: "shell", Command: "go test ./...", Passed: true, Duration: time.Second}},
+		Constraints: []constraintCheck{
+			{Name: "max_diff_lines", Detail: "2 of 800 lines", OK: true},
+		},
+	}
+	got, err := r.renderPRBody(data, "api")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"## Summary\n\nadd column\n",
+		"## Acceptance criteria\n\n- [ ] old rows keep working\n\n## Plan",
+		"1. add migration",
+		"**api** (into `main`)\n\n```\n db.go | 2 +-\n```",
+		"- ✅ `go test ./...` (test, 1s)",
+		"- ✅ max_diff_lines: 2 of 800 lines",
+		"run `run-1`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("body missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderPRBodyKeepsUnparsableTemplate(t *testing.T) {
+	dir := t.TempDir()
+	raw := "## Summary\n\nUse `{{` to open an action.\n"
+	if err := os.WriteFile(filepath.Join(dir, "pr.md"), []byte(raw), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := Runner{Spec: &spec.Spec{SourceDir: dir, Output: spec.Output{PRTemplate: "pr.md"}}}
+	got, err := r.renderPRBody(prData{}, "api")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != raw {
+		t.Errorf("body = %q, want %q", got, raw)
+	}
+}
+
+func TestPRReviewersFromCodeOwners(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".github"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	owners := "* @org/core\n/db/ @dba dba@example.com\n"
+	if err := os.WriteFile(filepath.Join(dir, ".github", "CODEOWNERS"), []byte(owners), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := Runner{Spec: &spec.Spec{Output: spec.Output{PR: spec.PR{Reviewers: []string{"dba"}, CodeOwners: true}}}}
+	rs := &repoState{spec: spec.RepoSpec{Name: "api"}, path: dir}
+	report := prData{Repos: []prRepo{{Name: "api", Files: []string{"db/001.sql", "main.go"}}}}
+
+	users, teams, err := r.prReviewers(rs, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(users, []string{"dba"}) || !slices.Equal(teams, []string{"org/core"}) {
+		t.Fatalf("want users [dba] and teams [org/core], got %v and %v", users, teams)
+	}
+}
+
+// recordingForge records the calls made to it.
+type recordingForge struct {
+	calls []string
+}
+
+func (f *recordingForge) FindOpen(context.Context, string) (*forge.ChangeRequest, error) {
+	return nil, nil
+}
+
+func (f *recordingForge) Create(context.Context, forge.CreateOptions) (*forge.ChangeRequest, error) {
+	return nil, nil
+}
+
+func (f *recordingForge) UpdateBody(_ context.Context, _ *forge.ChangeRequest, body string) error {
+	f.calls = append(f.calls, "body: "+body)
+	return nil
+}
+
+func (f *recordingForge) Comment(_ context.Context, _ *forge.ChangeRequest, body string) error {
+	f.calls = append(f.calls, "comment: "+strings.SplitN(body, "\n", 2)[0])
+	return nil
+}
+
+func (f *recordingForge) AddLabels(_ context.Context, _ *forge.ChangeRequest, labels []string) error {
+	f.calls = append(f.calls, "labels: "+strings.Join(labels, ","))
+	return nil
+}
+
+func (f *recordingForge) RequestReviewers(_ context.Context, _ *forge.ChangeRequest, users, teams []string) error {
+	f.calls = append(f.calls, "reviewers: "+strings.Join(append(users, teams...), ","))
+	if len(teams) > 0 {
+		return errors.New("teams are not supported")
+	}
+	return nil
+}
+
+func TestUpdatePRForNewIteration(t *testing.T) {
+	fg := &recordingForge{}
+	data := prData{RunID: "run-2", Feedback: "rename x"}
+	err := updatePR(context.Background(), fg, &forge.ChangeRequest{Number: 5}, "new body", iterationComment(data, "api"), []string{"bot"}, []string{"alice"}, []string{"org/core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"body: new body",
+		"comment: devspec run `run-2` pushed a new iteration.",
+		"labels: bot",
+		"reviewers: alice,org/core",
+	}
+	if !slices.Equal(fg.calls, want) {
+		t.Fatalf("want %q, got %q", want, fg.calls)
+	}
+}
+
+func TestRenderPromptOverrides(t *testing.T) {
+	r := Runner{Spec: &spec.Spec{Prompts: map[string]string{"review": "review.tmpl"}}}
+	st := &runState{promptTemplates: map[string]string{
+		"review.tmpl": "kind review: {{.Task}}",
+		"step.tmpl":   "step review: {{.Task}}{{with .Feedback}} ({{.}}){{end}}",
+	}}
+	in := prompt.Inputs{Spec: r.Spec, Task: "add column", Feedback: "rename"}
+
+	got, err := r.renderPrompt(st, prompt.KindReview, &spec.Step{Name: "self_review"}, in)
+	if err != nil || got != "kind review: add column" {
+		t.Fatalf("kind override: got %q, %v", got, err)
+	}
+	got, err = r.renderPrompt(st, prompt.KindReview, &spec.Step{PromptTemplate: "step.tmpl"}, in)
+	if err != nil || got != "step review: add column (rename)" {
+		t.Fatalf("step override: got %q, %v", got, err)
+	}
+	got, err = r.renderPrompt(st, prompt.KindImplement, nil, in)
+	if err != nil || !strings.Contains(got, "TASK:\nadd column\n\nCONSTRAINTS:") {
+		t.Fatalf("default: got %q, %v", got, err)
+	}
+	in.Acceptance = []string{"old rows keep working"}
+	got, err = r.renderPrompt(st, prompt.KindImplement, nil, in)
+	if err != nil || !strings.Contains(got, "TASK:\nadd column\n\nACCEPTANCE CRITERIA (the change must meet every item):\n- [ ] old rows keep working\n\nCONSTRAINTS:") {
+		t.Fatalf("acceptance: got %q, %v", got, err)
+	}
+}
+
+func TestPlanFiles(t *testing.T) {
+	plan := `## Steps
+1. Add the column.
+
+## Files
+- ` + "`internal/store/users.go`" + ` — add the field
+- .github/workflows/ci.yml: run migrations
+2. /work/api/migrations/002_users.sql
+- the README, if needed
+
+Risks:
+- internal/other.go breaks
+`
+	got := planFiles(plan)
+	want := []string{"internal/store/users.go", ".github/workflows/ci.yml", "/work/api/migrations/002_users.sql"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestLoadContextFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		".gitignore":           "gen/\n",
+		"docs/architecture.md": "# Architecture\n",
+		"docs/adr/001.md":      "# Use Postgres\n",
+		"api/users.proto":      strings.Repeat("message User {}\n", 10),
+		"api/v2/orders.proto":  "message Order {}\n",
+		"gen/api/users.proto":  "generated\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+
+	r := Runner{Spec: &spec.Spec{Context: spec.Context{
+		Files:        []string{"docs/architecture.md", "**/*.proto"},
+		MaxFileBytes: 40,
+	}}}
+	st := &runState{repos: []repoState{{spec: spec.RepoSpec{Name: "api"}, path: dir}}}
+	if err := r.loadContextFiles(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range st.files {
+		names = append(names, f.Name)
+	}
+	want := []string{"api: docs/architecture.md", "api: api/users.proto", "api: api/v2/orders.proto"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("want %v, got %v", want, names)
+	}
+	if got := st.files[0].Body; got != "==> api: docs/architecture.md\n# Architecture" {
+		t.Errorf("unexpected body %q", got)
+	}
+	if !strings.HasSuffix(st.files[1].Body, "more bytes of api/users.proto truncated ...]") {
+		t.Errorf("large file should be truncated:\n%s", st.files[1].Body)
+	}
+
+	st.planOutput = "Files:\n- api/v2/orders.proto\n- docs/\n- missing.go\n- " + filepath.Join(dir, ".gitignore")
+	if err := r.addPlanFiles(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	names = nil
+	for _, f := range st.files[3:] {
+		names = append(names, f.Name)
+	}
+	if want := []string{"api: .gitignore", "api: docs/adr/001.md"}; !slices.Equal(names, want) {
+		t.Fatalf("plan files: want %v, got %v", want, names)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "docs/architecture.md"), []byte("# Layers\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "api/users.proto")); err != nil {
+		t.Fatal(err)
+	}
+	bodies, err := r.contextBodies(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != len(st.files)-1 || bodies[0] != "==> api: docs/architecture.md\n# Layers" {
+		t.Fatalf("files should be read again for each step, got %q", bodies)
+	}
+}
+
+func TestFileHistory(t *testing.T) {
+	dir := t.TempDir()
+	git := func(date string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=a@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=a@example.com",
+			"GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "users.go"), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("2026-01-01T12:00:00Z", "init", "-q")
+	write("a\nb\nc\nd\n")
+	git("2026-01-01T12:00:00Z", "add", ".")
+	git("2026-01-01T12:00:00Z", "commit", "-qm", "Add users")
+	write("a\nB\nC\nd\n")
+	git("2026-10-01T12:00:00Z", "commit", "-qam", "Fix nil user")
+
+	r := Runner{
+		Spec: &spec.Spec{Context: spec.Context{GitLog: spec.GitLog{Commits: 5, FileCommits: 5, MaxAgeDays: 90}}},
+		Now:  func() time.Time { return time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC) },
+	}
+	st := &runState{
+		repos:      []repoState{{spec: spec.RepoSpec{Name: "api"}, path: dir}},
+		planOutput: "Files:\n- users.go\n- new.go\n",
+	}
+	if err := r.loadGitLog(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(st.gitLog, " 2026-10-01 Fix nil user (Alice)") || strings.Contains(st.gitLog, "Add users") {
+		t.Fatalf("want only the recent commit, got:\n%s", st.gitLog)
+	}
+	if err := r.addFileHistory(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"==> api: users.go\nlog:\n",
+		"blame (4 lines):\n  2 lines from ",
+		" 2026-10-01 Fix nil user (Alice)\n  2 lines unchanged in the last 90 days",
+	} {
+		if !strings.Contains(st.fileHistory, want) {
+			t.Errorf("missing %q in:\n%s", want, st.fileHistory)
+		}
+	}
+
+	r.Spec.Context.GitLog.MaxAgeDays = 0
+	if err := r.addFileHistory(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(st.fileHistory, " 2026-01-01 Add users (Alice)") || strings.Contains(st.fileHistory, "unchanged") {
+		t.Errorf("without max_age_days every commit should be named:\n%s", st.fileHistory)
+	}
+}
+
+func TestRenderPromptDescribesWorkspace(t *testing.T) {
+	r := Runner{Spec: &spec.Spec{}}
+	in := prompt.Inputs{Spec: r.Spec, Task: "add column"}
+	single := &runState{repos: []repoState{{spec: spec.RepoSpec{Name: "api"}, path: "/src/api"}}}
+	got, err := r.renderPrompt(single, prompt.KindImplement, nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "WORKSPACE:\nRepository root: /src/api\nPaths in the repo tree") || strings.Contains(got, "| Repo |") {
+		t.Fatalf("single repo:\n%s", got)
+	}
+
+	multi := &runState{repos: []repoState{
+		{spec: spec.RepoSpec{Name: "api", Description: "REST API"}, path: "/src/api"},
+		{spec: spec.RepoSpec{Name: "web"}, path: "/src/web"},
+	}}
+	got, err = r.renderPrompt(multi, prompt.KindReview, nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "| Repo | Root | Role |\n|------|------|------|\n| api | /src/api | REST API |\n| web | /src/web | - |") {
+		t.Fatalf("multi repo:\n%s", got)
+	}
+}
+
+func TestRenderPromptsLeavesRepoAlone(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runner{
+		Spec: &spec.Spec{
+			Workspace: spec.Workspace{Repos: []spec.RepoSpec{{Name: "api", Path: dir}}},
+			Agents: map[string]spec.Agent{
+				"planner": {Prompt: "plan it"},
+				"coder":   {Prompt: "code it"},
+			},
+			Steps: []spec.Step{
+				{Name: "plan", Agent: "planner", Mode: "plan"},
+				{Name: "test", Run: "go test ./..."},
+				{Name: "implement", Agent: "coder"},
+			},
+		},
+		Opts: Options{Task: "add column"},
+	}
+	var out strings.Builder
+	err := r.RenderPrompts(context.Background(), &out, PreviewOptions{Step: "implement", PlanOutput: "1. add it"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, "==> [3/3] implement (agent: coder, prompt: implement)\n~") ||
+		!strings.Contains(got, "PLAN OUTPUT:\n1. add it") || strings.Contains(got, "plan it") {
+		t.Fatalf("unexpected preview:\n%s", got)
+	}
+
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = stdout
+	r.Spec.Skills = []string{"---\nname: style\n---\n" + strings.Repeat("Keep changes small. ", 20)}
+	r.Spec.Context.MaxTokens = 20
+	if err := r.RenderPrompts(context.Background(), stdout, PreviewOptions{Step: "implement"}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(stdout.Name()); err != nil || strings.Contains(string(b), "context trimmed") {
+		t.Fatalf("the trim report should not be mixed into the prompts (%v):\n%s", err, b)
+	}
+
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err != nil || string(status) != "?? main.go\n" {
+		t.Fatalf("git state changed: %q, %v", status, err)
+	}
+}
+
+func TestRulesAreHiddenFromGitAndRestored(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	read := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	git("init", "-q")
+	if err := os.WriteFile(filepath.Join(dir, "AGENTS.md"), []byte("# Agents\n\nRun make test.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-qm", "init")
+	if err := os.WriteFile(filepath.Join(dir, ".git", "info", "exclude"), []byte("*.log\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runner{Spec: &spec.Spec{Rules: []spec.Rule{
+		{Name: "go-style", Content: "Wrap errors with %w.", Target: "cursor", Globs: []string{"**/*.go"}},
+		{Name: "agents", Content: "Never edit generated code.", Target: "agents"},
+		{Name: "claude", Content: "Prefer small diffs.", Target: "claude"},
+	}}}
+	st := &runState{repos: []repoState{{spec: spec.RepoSpec{Name: "api"}, path: dir}}}
+	if err := r.loadRules(st); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.injectRules(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(".cursor/rules/go-style.mdc"); got != "---\ndescription: \nglobs: **/*.go\nalwaysApply: false\n---\n\nWrap errors with %w.\n" {
+		t.Errorf("cursor rule: %q", got)
+	}
+	if got := read("AGENTS.md"); got != "# Agents\n\nRun make test.\n\nNever edit generated code.\n" {
+		t.Errorf("AGENTS.md: %q", got)
+	}
+	if got := read("CLAUDE.md"); got != "Prefer small diffs.\n" {
+		t.Errorf("CLAUDE.md: %q", got)
+	}
+	git("add", "-A")
+	if out := git("status", "--porcelain") + git("diff", "HEAD"); out != "" {
+		t.Fatalf("rules should be invisible to git, got:\n%s", out)
+	}
+
+	restored := func(how string) {
+		t.Helper()
+		if got := read("AGENTS.md"); got != "# Agents\n\nRun make test.\n" {
+			t.Errorf("%s: AGENTS.md not restored: %q", how, got)
+		}
+		for _, name := range []string{"CLAUDE.md", ".cursor"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+				t.Errorf("%s: %s should be removed: %v", how, name, err)
+			}
+		}
+		if exclude := read(".git/info/exclude"); exclude != "*.log\n" {
+			t.Errorf("%s: exclude not restored: %q", how, exclude)
+		}
+		if out := git("ls-files", "-v", "AGENTS.md"); out != "H AGENTS.md\n" {
+			t.Errorf("%s: skip-worktree not cleared: %q", how, out)
+		}
+	}
+	if err := r.restoreRules(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	restored("restoreRules")
+
+	// A run killed before restoring its rules leaves them to the next run.
+	if err := r.injectRules(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.cleanupRules(context.Background(), &runState{repos: st.repos}); err != nil {
+		t.Fatal(err)
+	}
+	restored("cleanupRules")
+}
+
+func TestStepSkillsFollowScope(t *testing.T) {
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "db", "migrations"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "db", "migrations", "0042.sql"), []byte("select 1;\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runner{Spec: &spec.Spec{
+		Workspace: spec.Workspace{Repos: []spec.RepoSpec{{Name: "api"}, {Name: "web"}}},
+		Steps:     []spec.Step{{Name: "plan", Agent: "planner"}, {Name: "implement", Agent: "coder"}},
+		Skills: []string{
+			"Keep changes small.",
+			"---\nname: web\nrepos: [web]\n---\nUse the design system.",
+			"---\nname: migrations\nkinds: [implement, review]\npaths: [\"db/migrations/\"]\n---\nNever edit released migrations.",
+			"---\nsteps: [plan]\n---\nPlan in small steps.",
+		},
+	}}
+	st := &runState{repos: []repoState{{spec: spec.RepoSpec{Name: "api"}, path: dir}}}
+	if err := r.loadSkills(st); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.stepSkills(context.Background(), st, "plan", prompt.KindPlan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "Keep changes small.|Plan in small steps." {
+		t.Errorf("plan skills: %q", got)
+	}
+	got, err = r.stepSkills(context.Background(), st, "implement", prompt.KindImplement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "Keep changes small.|Never edit released migrations." {
+		t.Errorf("implement skills: %q", got)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "db")); err != nil {
+		t.Fatal(err)
+	}
+	st.planOutput = "Files:\n- db/migrations/0043_orders.sql (new)\n"
+	got, err = r.stepSkills(context.Background(), st, "implement", prompt.KindImplement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != "Keep changes small.|Never edit released migrations." {
+		t.Errorf("implement skills for a planned file: %q", got)
+	}
+
+	r.Spec.Skills = []string{"---\nrepos: [mobile]\n---\nx"}
+	if err := r.loadSkills(&runState{}); err == nil || !strings.Contains(err.Error(), `no repo named "mobile"`) {
+		t.Errorf("expected an unknown repo error, got %v", err)
+	}
+}
+
+func TestPRModelFollowsAgentModels(t *testing.T) {
+	r := Runner{Spec: &spec.Spec{
+		Model:  "base-model",
+		Agents: map[string]spec.Agent{"planner": {Model: "plan-model"}, "coder": {}},
+	}}
+	steps := []stepResult{
+		r.newStepResult(spec.Step{Name: "plan", Agent: "planner", Mode: "plan"}),
+		r.newStepResult(spec.Step{Name: "test", Run: "go test ./..."}),
+		r.newStepResult(spec.Step{Name: "implement", Agent: "coder"}),
+	}
+	if got := r.prModel(steps); got != "plan: plan-model, implement: base-model" {
+		t.Errorf("got %q", got)
+	}
+
+	r.Opts.ModelOverride = "override"
+	steps = []stepResult{r.newStepResult(spec.Step{Name: "plan", Agent: "planner"}), r.newStepResult(spec.Step{Name: "implement", Agent: "coder"})}
+	if got := r.prModel(steps); got != "override" {
+		t.Errorf("with an override, got %q", got)
+	}
+	if got := r.prModel(nil); got != "override" {
+		t.Errorf("without agent steps, got %q", got)
+	}
+}
+
+func TestPRReportCoversEarlierIterations(t *testing.T) {
+	dir, origin := t.TempDir(), t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	commit := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("package api\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", name)
+		git("commit", "-qm", "add "+name)
+	}
+	git("init", "-q", "--bare", "-b", "main", origin)
+	git("init", "-q", "-b", "main")
+	git("remote", "add", "origin", origin)
+	git("commit", "-q", "--allow-empty", "-m", "init")
+	git("push", "-q", "origin", "main")
+	git("checkout", "-q", "-b", "agent/orders")
+	commit("earlier.go")
+	git("push", "-q", "origin", "agent/orders")
+	git("checkout", "-q", "main")
+
+	r := Runner{
+		Spec: &spec.Spec{Workspace: spec.Workspace{Repos: []spec.RepoSpec{
+			{Name: "api", Path: dir, BaseBranch: "main", Remote: "origin", PushRemote: "origin"},
+		}}},
+		Opts: Options{Branch: "agent/orders"},
+	}
+	st := &runState{}
+	if err := r.openRepos(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setupWorkspace(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+	commit("run.go")
+
+	data, err := r.prReport(context.Background(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := data.Repos[0]
+	if strings.Join(rp.Files, ",") != "earlier.go,run.go" || !strings.Contains(rp.DiffStat, "earlier.go") {
+		t.Errorf("the body should cover the whole pull request: %v\n%s", rp.Files, rp.DiffStat)
+	}
+	comment := iterationComment(data, "api")
+	if !strings.Contains(comment, "run.go") || strings.Contains(comment, "earlier.go") {
+		t.Errorf("the comment should only cover this run:\n%s", comment)
+	}
+}
+
+func TestRulesDefaultToTheRuntimeTarget(t *testing.T) {
+	for binary, want := range map[string]string{"agent": ".cursor/rules/style.mdc", "claude": "CLAUDE.md", "codex": "AGENTS.md"} {
+		r := Runner{Spec: &spec.Spec{Binary: binary, Rules: []spec.Rule{{Name: "style", Content: "Keep diffs small."}}}}
+		st := &runState{}
+		if err := r.loadRules(st); err != nil {
+			t.Fatal(err)
+		}
+		if len(st.ruleFiles) != 1 || st.ruleFiles[0].rel != want {
+			t.Errorf("binary %s: want %s, got %+v", binary, want, st.ruleFiles)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+	"github.com/threatlevelmidnight10/devspec/internal/skill"
+	"github.com/threatlevelmidnight10/devspec/internal/spec"
+)
+
+// loadSkills reads the skills and checks that the steps, prompt kinds and
+// repos their frontmatter names exist. Skills without a name are named
+// after their spec entry.
+func (r *Runner) loadSkills(st *runState) error {
+	for i, raw := range r.Spec.Skills {
+		body, err := r.resolveContent(raw)
+		if err != nil {
+			return fmt.Errorf("resolve skill: %w", err)
+		}
+		name := r.skillName(i)
+		sk, err := skill.Parse(body)
+		if err != nil {
+			return fmt.Errorf("skill %s: %w", name, err)
+		}
+		if sk.Name == "" {
+			sk.Name = name
+		}
+		for _, step := range sk.Steps {
+			if !slices.ContainsFunc(r.Spec.Steps, func(s spec.Step) bool { return s.Name == step && s.Agent != "" }) {
+				return fmt.Errorf("skill %s: steps: no agent step named %q", sk.Name, step)
+			}
+		}
+		for _, kind := range sk.Kinds {
+			if !slices.Contains(spec.PromptKinds, kind) {
+				return fmt.Errorf("skill %s: kinds: %q is not a prompt kind", sk.Name, kind)
+			}
+		}
+		for _, repo := range sk.Repos {
+			if !slices.ContainsFunc(r.Spec.Workspace.Repos, func(rs spec.RepoSpec) bool { return rs.Name == repo }) {
+				return fmt.Errorf("skill %s: repos: no repo named %q", sk.Name, repo)
+			}
+		}
+		st.skills = append(st.skills, sk)
+	}
+	return nil
+}
+
+// stepSkills returns the bodies of the skills that apply to a step. Skills
+// scoped to repos or paths only apply once the plan names, or the run has
+// changed, a file they concern.
+func (r *Runner) stepSkills(ctx context.Context, st *runState, step, kind string) ([]string, error) {
+	var bodies []string
+	var touched []planTarget
+	loaded := false
+	for _, sk := range st.skills {
+		if !sk.ForStep(step, kind) {
+			continue
+		}
+		if sk.Scoped() {
+			if !loaded {
+				var err error
+				if touched, err = touchedFiles(ctx, st); err != nil {
+					return nil, err
+				}
+				loaded = true
+			}
+			if !slices.ContainsFunc(touched, func(t planTarget) bool { return sk.Concerns(t.repo.spec.Name, t.rel) }) {
+				continue
+			}
+		}
+		bodies = append(bodies, sk.Body)
+	}
+	return bodies, nil
+}
+
+// touchedFiles lists the paths the plan names, including files it is yet
+// to create, the files below directories it names, and those the run has
+// changed or added so far.
+func touchedFiles(ctx context.Context, st *runState) ([]planTarget, error) {
+	files, err := planTargets(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, plannedPaths(st)...)
+	for _, rs := range st.repos {
+		changed, err := repoChangedFiles(ctx, rs)
+		if err != nil {
+			return nil, err
+		}
+		added, err := gitutil.UntrackedFiles(ctx, rs.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range append(changed, added...) {
+			files = append(files, planTarget{repo: rs, rel: f})
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,137 @@
+// Package forge opens and updates change requests — GitHub pull requests,
+// GitLab merge requests, Gitea and Forgejo pull requests — for agent
+// branches.
+package forge
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+const (
+	GitHub  = "github"
+	GitLab  = "gitlab"
+	Gitea   = "gitea"
+	Forgejo = "forgejo"
+)
+
+// Kinds lists the supported forges.
+var Kinds = []string{GitHub, GitLab, Gitea, Forgejo}
+
+// ChangeRequest is a pull request or merge request.
+type ChangeRequest struct {
+	Number int
+	URL    string
+}
+
+type CreateOptions struct {
+	Base          string
+	Head          string
+	Title         string
+	Body          string
+	Draft         bool
+	Labels        []string
+	Reviewers     []string
+	TeamReviewers []string // "org/team"
+	Assignees     []string
+	Milestone     string
+}
+
+type Forge interface {
+	// FindOpen returns the open change request for the head branch, or nil.
+	FindOpen(ctx context.Context, head string) (*ChangeRequest, error)
+	// Create opens a change request. If it was opened but the reviewers
+	// could not be requested, it is returned along with the error.
+	Create(ctx context.Context, opts CreateOptions) (*ChangeRequest, error)
+	UpdateBody(ctx context.Context, cr *ChangeRequest, body string) error
+	Comment(ctx context.Context, cr *ChangeRequest, body string) error
+	AddLabels(ctx context.Context, cr *ChangeRequest, labels []string) error
+	// RequestReviewers asks users and teams, named "org/team", to review.
+	RequestReviewers(ctx context.Context, cr *ChangeRequest, users, teams []string) error
+}
+
+// Repo locates the repository change requests are opened against.
+type Repo struct {
+	// Dir is the local checkout; forge CLIs run in it.
+	Dir string
+	// Target is the repository the change request is opened against. It is
+	// zero when the remote URL could not be parsed.
+	Target gitutil.RemoteRepo
+	// Fork is the repository the head branch was pushed to, when that is
+	// not Target.
+	Fork gitutil.RemoteRepo
+}
+
+// Config holds settings for the API-based forges.
+type Config struct {
+	// URL is the forge's web root; it defaults to https://<remote host>.
+	URL string
+	// TokenEnv names the environment variable holding the API token.
+	TokenEnv string
+}
+
+func (c Config) token() string {
+	if c.TokenEnv == "" {
+		return ""
+	}
+	return strings.TrimSpace(os.Getenv(c.TokenEnv))
+}
+
+func New(kind string, repo Repo, cfg Config) (Forge, error) {
+	switch kind {
+	case GitHub:
+		return &gitHub{repo: repo}, nil
+	case GitLab:
+		return &gitLab{repo: repo}, nil
+	case Gitea, Forgejo:
+		return newGitea(repo, cfg)
+	}
+	return nil, fmt.Errorf("unknown forge %q; allowed: %s", kind, strings.Join(Kinds, ", "))
+}
+
+// Detect guesses the forge from a remote host name, defaulting to GitHub.
+func Detect(host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case strings.Contains(host, "gitlab"):
+		return GitLab
+	case strings.Contains(host, "gitea"), strings.Contains(host, "forgejo"), host == "codeberg.org":
+		return Gitea
+	}
+	return GitHub
+}
+
+func runCLI(ctx context.Context, dir, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %w\n%s", name, strings.Join(args[:min(2, len(args))], " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// lastURL returns the last line of out that looks like a URL; CLIs print
+// progress before it.
+func lastURL(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			return line
+		}
+	}
+	return ""
+}
+
+// numberFromURL extracts the trailing number of a change request URL.
+func numberFromURL(u string) int {
+	u = strings.TrimRight(u, "/")
+	var n int
+	fmt.Sscanf(u[strings.LastIndex(u, "/")+1:], "%d", &n)
+	return n
+}
@@ -0,0 +1,146 @@
+package forge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// fakeCLI installs an executable called name on PATH that appends its
+// arguments, one per line, to a log and prints output.
+func fakeCLI(t *testing.T, name, output string) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args.log")
+	script := "#!/bin/sh\nfor a in \"$@\"; do printf '%s\\n' \"$a\" >> " + log + "; done\necho --- >> " + log + "\ncat <<'EOF'\n" + output + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func readArgs(t *testing.T, log string) string {
+	t.Helper()
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestDetect(t *testing.T) {
+	for host, want := range map[string]string{
+		"github.com":         GitHub,
+		"gitlab.com":         GitLab,
+		"gitlab.example.com": GitLab,
+		"git.example.com":    GitHub,
+		"gitea.example.com":  Gitea,
+		"codeberg.org":       Gitea,
+		"":                   GitHub,
+	} {
+		if got := Detect(host); got != want {
+			t.Errorf("Detect(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestGitHubCreateFromFork(t *testing.T) {
+	log := fakeCLI(t, "gh", "Creating pull request for acme:agent/x into main\nhttps://github.com/org/app/pull/42")
+	fg, err := New(GitHub, Repo{
+		Dir:    t.TempDir(),
+		Target: gitutil.RemoteRepo{Host: "github.com", Owner: "org", Name: "app"},
+		Fork:   gitutil.RemoteRepo{Host: "github.com", Owner: "acme", Name: "app"},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr, err := fg.Create(context.Background(), CreateOptions{Base: "main", Head: "agent/x", Title: "t", Body: "b", Labels: []string{"bot", "ai"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Number != 42 || cr.URL != "https://github.com/org/app/pull/42" {
+		t.Fatalf("unexpected change request %+v", cr)
+	}
+	args := readArgs(t, log)
+	for _, want := range []string{"--head\nacme:agent/x\n", "--label\nbot,ai\n", "--repo\ngithub.com/org/app\n"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("gh args missing %q:\n%s", want, args)
+		}
+	}
+}
+
+func TestGitHubFindOpen(t *testing.T) {
+	fakeCLI(t, "gh", `[{"number":7,"url":"https://github.com/org/app/pull/7"}]`)
+	fg, _ := New(GitHub, Repo{Dir: t.TempDir()}, Config{})
+	cr, err := fg.FindOpen(context.Background(), "agent/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr == nil || cr.Number != 7 {
+		t.Fatalf("FindOpen = %+v, want #7", cr)
+	}
+}
+
+func TestGitHubFindOpenMatchesForkOwner(t *testing.T) {
+	fakeCLI(t, "gh", `[
+{"number":7,"url":"https://github.com/org/app/pull/7","headRepositoryOwner":{"login":"someone"}},
+{"number":8,"url":"https://github.com/org/app/pull/8","headRepositoryOwner":{"login":"Acme"}}]`)
+	repo := Repo{
+		Dir:    t.TempDir(),
+		Target: gitutil.RemoteRepo{Host: "github.com", Owner: "org", Name: "app"},
+		Fork:   gitutil.RemoteRepo{Host: "github.com", Owner: "acme", Name: "app"},
+	}
+	fg, _ := New(GitHub, repo, Config{})
+	cr, err := fg.FindOpen(context.Background(), "agent/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr == nil || cr.Number != 8 {
+		t.Fatalf("FindOpen = %+v, want #8", cr)
+	}
+
+	repo.Fork = gitutil.RemoteRepo{}
+	fg, _ = New(GitHub, repo, Config{})
+	if cr, err := fg.FindOpen(context.Background(), "agent/x"); err != nil || cr != nil {
+		t.Fatalf("FindOpen without fork = %+v, %v; want nil, nil", cr, err)
+	}
+}
+
+func TestGitLabCreateAndUpdate(t *testing.T) {
+	log := fakeCLI(t, "glab", "Creating merge request for agent/x into main in group/app\n\nhttps://gitlab.com/group/app/-/merge_requests/3")
+	fg, _ := New(GitLab, Repo{
+		Dir:    t.TempDir(),
+		Target: gitutil.RemoteRepo{Host: "gitlab.com", Owner: "group", Name: "app"},
+	}, Config{})
+	ctx := context.Background()
+	cr, err := fg.Create(ctx, CreateOptions{Base: "main", Head: "agent/x", Title: "t", Body: "b", Draft: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Number != 3 {
+		t.Fatalf("unexpected change request %+v", cr)
+	}
+	if err := fg.RequestReviewers(ctx, cr, []string{"alice"}, []string{"group/dba"}); err == nil || !strings.Contains(err.Error(), "skipped group/dba") {
+		t.Fatalf("groups should be skipped with an error, got %v", err)
+	}
+	args := readArgs(t, log)
+	for _, want := range []string{"--source-branch\nagent/x\n", "--target-branch\nmain\n", "--draft\n", "mr\nupdate\n3\n--reviewer\nalice\n", "--repo\nhttps://gitlab.com/group/app\n"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("glab args missing %q:\n%s", want, args)
+		}
+	}
+}
+
+func TestGitLabFindOpenNone(t *testing.T) {
+	fakeCLI(t, "glab", "[]")
+	fg, _ := New(GitLab, Repo{Dir: t.TempDir()}, Config{})
+	cr, err := fg.FindOpen(context.Background(), "agent/x")
+	if err != nil || cr != nil {
+		t.Fatalf("FindOpen = %+v, %v; want nil, nil", cr, err)
+	}
+}
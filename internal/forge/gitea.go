@@ -0,0 +1,257 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// giteaDraftPrefix marks a pull request as a work in progress; Gitea and
+// Forgejo have no separate draft flag.
+const giteaDraftPrefix = "WIP: "
+
+// gitea talks to the Gitea/Forgejo REST API.
+type gitea struct {
+	repo   Repo
+	base   string // API root, e.g. https://git.example.com/api/v1
+	token  string
+	client *http.Client
+}
+
+type giteaPR struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref  string `json:"ref"`
+		Repo *struct {
+			Owner struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+		} `json:"repo"`
+	} `json:"head"`
+}
+
+func newGitea(repo Repo, cfg Config) (*gitea, error) {
+	if repo.Target.Name == "" {
+		return nil, fmt.Errorf("gitea forge: cannot tell owner and repository from the remote URL")
+	}
+	base := strings.TrimSpace(cfg.URL)
+	if base == "" {
+		base = "https://" + repo.Target.Host
+	}
+	token := cfg.token()
+	if token == "" {
+		return nil, fmt.Errorf("gitea forge: no API token in $%s", cfg.TokenEnv)
+	}
+	return &gitea{
+		repo:   repo,
+		base:   strings.TrimRight(base, "/") + "/api/v1",
+		token:  token,
+		client: http.DefaultClient,
+	}, nil
+}
+
+// do sends a request for a path under the target repository and decodes
+// the JSON response into out, if out is not nil.
+func (g *gitea) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := fmt.Sprintf("%s/repos/%s/%s%s", g.base, url.PathEscape(g.repo.Target.Owner), url.PathEscape(g.repo.Target.Name), path)
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+g.token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gitea %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		b, _ := io.ReadAll(resp.Body)
+		msg := strings.TrimSpace(string(b))
+		if json.Unmarshal(b, &apiErr) == nil && apiErr.Message != "" {
+			msg = apiErr.Message
+		}
+		return fmt.Errorf("gitea %s %s: %s: %s", method, path, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode gitea %s response: %w", path, err)
+	}
+	return nil
+}
+
+func (g *gitea) FindOpen(ctx context.Context, head string) (*ChangeRequest, error) {
+	owner := g.repo.Fork.Owner
+	if owner == "" {
+		owner = g.repo.Target.Owner
+	}
+	for page := 1; ; page++ {
+		var prs []giteaPR
+		if err := g.do(ctx, http.MethodGet, fmt.Sprintf("/pulls?state=open&limit=50&page=%d", page), nil, &prs); err != nil {
+			return nil, err
+		}
+		if len(prs) == 0 {
+			return nil, nil
+		}
+		for _, pr := range prs {
+			if pr.Head.Ref != head {
+				continue
+			}
+			if pr.Head.Repo != nil && !strings.EqualFold(pr.Head.Repo.Owner.Login, owner) {
+				continue
+			}
+			return &ChangeRequest{Number: pr.Number, URL: pr.HTMLURL}, nil
+		}
+	}
+}
+
+func (g *gitea) Create(ctx context.Context, opts CreateOptions) (*ChangeRequest, error) {
+	head := opts.Head
+	if g.repo.Fork.Owner != "" {
+		head = g.repo.Fork.Owner + ":" + head
+	}
+	title := opts.Title
+	if opts.Draft {
+		title = giteaDraftPrefix + title
+	}
+	labels, err := g.labelIDs(ctx, opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+	in := map[string]any{
+		"base":  opts.Base,
+		"head":  head,
+		"title": title,
+		"body":  opts.Body,
+	}
+	if len(labels) > 0 {
+		in["labels"] = labels
+	}
+	if len(opts.Assignees) > 0 {
+		in["assignees"] = opts.Assignees
+	}
+	if opts.Milestone != "" {
+		id, err := g.milestoneID(ctx, opts.Milestone)
+		if err != nil {
+			return nil, err
+		}
+		in["milestone"] = id
+	}
+	var pr giteaPR
+	if err := g.do(ctx, http.MethodPost, "/pulls", in, &pr); err != nil {
+		return nil, err
+	}
+	cr := &ChangeRequest{Number: pr.Number, URL: pr.HTMLURL}
+	if err := g.RequestReviewers(ctx, cr, opts.Reviewers, opts.TeamReviewers); err != nil {
+		return cr, err
+	}
+	return cr, nil
+}
+
+func (g *gitea) UpdateBody(ctx context.Context, cr *ChangeRequest, body string) error {
+	return g.do(ctx, http.MethodPatch, fmt.Sprintf("/pulls/%d", cr.Number), map[string]string{"body": body}, nil)
+}
+
+func (g *gitea) Comment(ctx context.Context, cr *ChangeRequest, body string) error {
+	return g.do(ctx, http.MethodPost, fmt.Sprintf("/issues/%d/comments", cr.Number), map[string]string{"body": body}, nil)
+}
+
+func (g *gitea) AddLabels(ctx context.Context, cr *ChangeRequest, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	ids, err := g.labelIDs(ctx, labels)
+	if err != nil {
+		return err
+	}
+	return g.do(ctx, http.MethodPost, fmt.Sprintf("/issues/%d/labels", cr.Number), map[string][]int64{"labels": ids}, nil)
+}
+
+// RequestReviewers asks users and teams to review. The API takes team names
+// without their organization, which is the target repository's owner.
+func (g *gitea) RequestReviewers(ctx context.Context, cr *ChangeRequest, users, teams []string) error {
+	if len(users) == 0 && len(teams) == 0 {
+		return nil
+	}
+	in := map[string][]string{"reviewers": users}
+	for _, team := range teams {
+		in["team_reviewers"] = append(in["team_reviewers"], team[strings.LastIndex(team, "/")+1:])
+	}
+	return g.do(ctx, http.MethodPost, fmt.Sprintf("/pulls/%d/requested_reviewers", cr.Number), in, nil)
+}
+
+// labelIDs looks up the repository's label IDs by name; the API only takes
+// IDs.
+func (g *gitea) labelIDs(ctx context.Context, names []string) ([]int64, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byName := make(map[string]int64)
+	for page := 1; ; page++ {
+		var labels []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		}
+		if err := g.do(ctx, http.MethodGet, fmt.Sprintf("/labels?limit=50&page=%d", page), nil, &labels); err != nil {
+			return nil, err
+		}
+		if len(labels) == 0 {
+			break
+		}
+		for _, l := range labels {
+			byName[strings.ToLower(l.Name)] = l.ID
+		}
+	}
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("gitea: label %q does not exist in %s", name, g.repo.Target.FullName())
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// milestoneID looks up a milestone's ID by title.
+func (g *gitea) milestoneID(ctx context.Context, title string) (int64, error) {
+	for page := 1; ; page++ {
+		var milestones []struct {
+			ID    int64  `json:"id"`
+			Title string `json:"title"`
+		}
+		if err := g.do(ctx, http.MethodGet, fmt.Sprintf("/milestones?state=all&limit=50&page=%d", page), nil, &milestones); err != nil {
+			return 0, err
+		}
+		if len(milestones) == 0 {
+			return 0, fmt.Errorf("gitea: milestone %q does not exist in %s", title, g.repo.Target.FullName())
+		}
+		for _, m := range milestones {
+			if strings.EqualFold(m.Title, title) {
+				return m.ID, nil
+			}
+		}
+	}
+}
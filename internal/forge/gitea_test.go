@@ -0,0 +1,186 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/threatlevelmidnight10/devspec/internal/gitutil"
+)
+
+// fakeGitea is an in-memory stand-in for the parts of the Gitea API the
+// driver uses.
+type fakeGitea struct {
+	mu        sync.Mutex
+	prs       []map[string]any
+	bodies    map[int]string
+	labels    map[int][]int64
+	reviewers map[int][]string
+	comments  map[int][]string
+}
+
+func newFakeGitea(t *testing.T) (*fakeGitea, *httptest.Server) {
+	f := &fakeGitea{
+		bodies:    map[int]string{},
+		labels:    map[int][]int64{},
+		reviewers: map[int][]string{},
+		comments:  map[int][]string{},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"message": "token is required"})
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1/repos/org/app")
+		parts := strings.Split(strings.Trim(path, "/"), "/")
+		var in map[string]any
+		json.NewDecoder(r.Body).Decode(&in)
+		page := r.URL.Query().Get("page")
+		switch {
+		case r.Method == http.MethodGet && path == "/labels":
+			if page != "1" {
+				w.Write([]byte("[]"))
+				return
+			}
+			w.Write([]byte(`[{"id":11,"name":"bot"},{"id":12,"name":"needs-review"}]`))
+		case r.Method == http.MethodGet && path == "/pulls":
+			if page != "1" {
+				w.Write([]byte("[]"))
+				return
+			}
+			json.NewEncoder(w).Encode(f.prs)
+		case r.Method == http.MethodPost && path == "/pulls":
+			n := len(f.prs) + 1
+			head := in["head"].(string)
+			owner := "org"
+			if i := strings.Index(head, ":"); i >= 0 {
+				owner, head = head[:i], head[i+1:]
+			}
+			pr := map[string]any{
+				"number":   n,
+				"html_url": "http://gitea/org/app/pulls/" + strconv.Itoa(n),
+				"title":    in["title"],
+				"head":     map[string]any{"ref": head, "repo": map[string]any{"owner": map[string]any{"login": owner}}},
+			}
+			f.prs = append(f.prs, pr)
+			f.bodies[n] = in["body"].(string)
+			labels, _ := in["labels"].([]any)
+			for _, id := range labels {
+				f.labels[n] = append(f.labels[n], int64(id.(float64)))
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(pr)
+		case r.Method == http.MethodPatch && parts[0] == "pulls":
+			n, _ := strconv.Atoi(parts[1])
+			f.bodies[n] = in["body"].(string)
+			w.Write([]byte("{}"))
+		case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "requested_reviewers":
+			n, _ := strconv.Atoi(parts[1])
+			users, _ := in["reviewers"].([]any)
+			teams, _ := in["team_reviewers"].([]any)
+			for _, name := range users {
+				f.reviewers[n] = append(f.reviewers[n], name.(string))
+			}
+			for _, name := range teams {
+				f.reviewers[n] = append(f.reviewers[n], "team:"+name.(string))
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("[]"))
+		case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "comments":
+			n, _ := strconv.Atoi(parts[1])
+			f.comments[n] = append(f.comments[n], in["body"].(string))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"message": "not found"})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func TestGiteaCreateFindAndUpdate(t *testing.T) {
+	f, srv := newFakeGitea(t)
+	t.Setenv("TEST_GITEA_TOKEN", "secret")
+	fg, err := New(Gitea, Repo{
+		Target: gitutil.RemoteRepo{Host: "gitea", Owner: "org", Name: "app"},
+		Fork:   gitutil.RemoteRepo{Host: "gitea", Owner: "me", Name: "app"},
+	}, Config{URL: srv.URL, TokenEnv: "TEST_GITEA_TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if cr, err := fg.FindOpen(ctx, "agent/x"); err != nil || cr != nil {
+		t.Fatalf("FindOpen before create = %+v, %v", cr, err)
+	}
+	cr, err := fg.Create(ctx, CreateOptions{
+		Base: "main", Head: "agent/x", Title: "add thing", Body: "v1",
+		Draft: true, Labels: []string{"bot"}, Reviewers: []string{"alice"}, TeamReviewers: []string{"org/core"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.prs[0]["title"]; got != "WIP: add thing" {
+		t.Errorf("title = %q, want WIP prefix", got)
+	}
+	if got := f.labels[cr.Number]; len(got) != 1 || got[0] != 11 {
+		t.Errorf("labels = %v, want [11]", got)
+	}
+	if got := f.reviewers[cr.Number]; strings.Join(got, ",") != "alice,team:core" {
+		t.Errorf("reviewers = %v, want [alice team:core]", got)
+	}
+
+	again, err := fg.FindOpen(ctx, "agent/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == nil || again.Number != cr.Number {
+		t.Fatalf("FindOpen after create = %+v, want #%d", again, cr.Number)
+	}
+	if err := fg.UpdateBody(ctx, again, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fg.Comment(ctx, again, "rerun"); err != nil {
+		t.Fatal(err)
+	}
+	if f.bodies[cr.Number] != "v2" || len(f.comments[cr.Number]) != 1 {
+		t.Errorf("body = %q, comments = %v", f.bodies[cr.Number], f.comments[cr.Number])
+	}
+}
+
+func TestGiteaErrors(t *testing.T) {
+	_, srv := newFakeGitea(t)
+	repo := Repo{Target: gitutil.RemoteRepo{Host: "gitea", Owner: "org", Name: "app"}}
+	ctx := context.Background()
+
+	t.Setenv("TEST_GITEA_TOKEN", "")
+	if _, err := New(Gitea, repo, Config{URL: srv.URL, TokenEnv: "TEST_GITEA_TOKEN"}); err == nil {
+		t.Fatal("expected an error without a token")
+	}
+
+	t.Setenv("TEST_GITEA_TOKEN", "wrong")
+	fg, err := New(Forgejo, repo, Config{URL: srv.URL, TokenEnv: "TEST_GITEA_TOKEN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fg.FindOpen(ctx, "agent/x"); err == nil || !strings.Contains(err.Error(), "token is required") {
+		t.Fatalf("FindOpen with bad token = %v, want API message", err)
+	}
+
+	t.Setenv("TEST_GITEA_TOKEN", "secret")
+	fg, _ = New(Forgejo, repo, Config{URL: srv.URL, TokenEnv: "TEST_GITEA_TOKEN"})
+	_, err = fg.Create(ctx, CreateOptions{Base: "main", Head: "agent/x", Labels: []string{"missing"}})
+	if err == nil || !strings.Contains(err.Error(), `label "missing"`) {
+		t.Fatalf("Create with unknown label = %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// gitHub drives the gh CLI.
+type gitHub struct {
+	repo Repo
+}
+
+func (g *gitHub) run(ctx context.Context, args ...string) (string, error) {
+	if t := g.repo.Target; t.Name != "" {
+		args = append(args, "--repo", t.Host+"/"+t.FullName())
+	}
+	return runCLI(ctx, g.repo.Dir, "gh", args...)
+}
+
+// FindOpen matches the head branch in the repository it was pushed to; gh
+// filters on the branch name alone, which other forks may share.
+func (g *gitHub) FindOpen(ctx context.Context, head string) (*ChangeRequest, error) {
+	out, err := g.run(ctx, "pr", "list", "--head", head, "--state", "open", "--json", "number,url,headRepositoryOwner")
+	if err != nil {
+		return nil, err
+	}
+	var prs []struct {
+		Number int    `json:"number"`
+		URL    string `json:"url"`
+		Owner  struct {
+			Login string `json:"login"`
+		} `json:"headRepositoryOwner"`
+	}
+	if err := json.Unmarshal([]byte(out), &prs); err != nil {
+		return nil, fmt.Errorf("decode gh pr list output: %w", err)
+	}
+	owner := g.repo.Fork.Owner
+	if owner == "" {
+		owner = g.repo.Target.Owner
+	}
+	for _, pr := range prs {
+		if owner == "" || strings.EqualFold(pr.Owner.Login, owner) {
+			return &ChangeRequest{Number: pr.Number, URL: pr.URL}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (g *gitHub) Create(ctx context.Context, opts CreateOptions) (*ChangeRequest, error) {
+	head := opts.Head
+	if g.repo.Fork.Owner != "" {
+		head = g.repo.Fork.Owner + ":" + head
+	}
+	args := []string{"pr", "create",
+		"--base", opts.Base,
+		"--head", head,
+		"--title", opts.Title,
+		"--body", opts.Body,
+	}
+	if opts.Draft {
+		args = append(args, "--draft")
+	}
+	if len(opts.Labels) > 0 {
+		args = append(args, "--label", strings.Join(opts.Labels, ","))
+	}
+	if reviewers := append(slices.Clone(opts.Reviewers), opts.TeamReviewers...); len(reviewers) > 0 {
+		args = append(args, "--reviewer", strings.Join(reviewers, ","))
+	}
+	if len(opts.Assignees) > 0 {
+		args = append(args, "--assignee", strings.Join(opts.Assignees, ","))
+	}
+	if opts.Milestone != "" {
+		args = append(args, "--milestone", opts.Milestone)
+	}
+	out, err := g.run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	u := lastURL(out)
+	if u == "" {
+		return nil, fmt.Errorf("gh pr create printed no pull request URL:\n%s", strings.TrimSpace(out))
+	}
+	return &ChangeRequest{Number: numberFromURL(u), URL: u}, nil
+}
+
+func (g *gitHub) UpdateBody(ctx context.Context, cr *ChangeRequest, body string) error {
+	_, err := g.run(ctx, "pr", "edit", cr.URL, "--body", body)
+	return err
+}
+
+func (g *gitHub) Comment(ctx context.Context, cr *ChangeRequest, body string) error {
+	_, err := g.run(ctx, "pr", "comment", cr.URL, "--body", body)
+	return err
+}
+
+func (g *gitHub) AddLabels(ctx context.Context, cr *ChangeRequest, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	_, err := g.run(ctx, "pr", "edit", cr.URL, "--add-label", strings.Join(labels, ","))
+	return err
+}
+
+// RequestReviewers asks users and teams alike; gh takes "org/team" names.
+func (g *gitHub) RequestReviewers(ctx context.Context, cr *ChangeRequest, users, teams []string) error {
+	reviewers := append(slices.Clone(users), teams...)
+	if len(reviewers) == 0 {
+		return nil
+	}
+	_, err := g.run(ctx, "pr", "edit", cr.URL, "--add-reviewer", strings.Join(reviewers, ","))
+	return err
+}
@@ -0,0 +1,115 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gitLab drives the glab CLI.
+type gitLab struct {
+	repo Repo
+}
+
+func (g *gitLab) run(ctx context.Context, args ...string) (string, error) {
+	if t := g.repo.Target; t.Name != "" {
+		args = append(args, "--repo", "https://"+t.Host+"/"+t.FullName())
+	}
+	return runCLI(ctx, g.repo.Dir, "glab", args...)
+}
+
+func (g *gitLab) FindOpen(ctx context.Context, head string) (*ChangeRequest, error) {
+	out, err := g.run(ctx, "mr", "list", "--source-branch", head, "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	var mrs []struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+	if err := json.Unmarshal([]byte(out), &mrs); err != nil {
+		return nil, fmt.Errorf("decode glab mr list output: %w", err)
+	}
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+	return &ChangeRequest{Number: mrs[0].IID, URL: mrs[0].WebURL}, nil
+}
+
+func (g *gitLab) Create(ctx context.Context, opts CreateOptions) (*ChangeRequest, error) {
+	args := []string{"mr", "create",
+		"--target-branch", opts.Base,
+		"--source-branch", opts.Head,
+		"--title", opts.Title,
+		"--description", opts.Body,
+		"--yes",
+	}
+	if g.repo.Fork.Name != "" {
+		args = append(args, "--head", g.repo.Fork.FullName())
+	}
+	if opts.Draft {
+		args = append(args, "--draft")
+	}
+	if len(opts.Labels) > 0 {
+		args = append(args, "--label", strings.Join(opts.Labels, ","))
+	}
+	if len(opts.Reviewers) > 0 {
+		args = append(args, "--reviewer", strings.Join(opts.Reviewers, ","))
+	}
+	if len(opts.Assignees) > 0 {
+		args = append(args, "--assignee", strings.Join(opts.Assignees, ","))
+	}
+	if opts.Milestone != "" {
+		args = append(args, "--milestone", opts.Milestone)
+	}
+	out, err := g.run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	u := lastURL(out)
+	if u == "" {
+		return nil, fmt.Errorf("glab mr create printed no merge request URL:\n%s", strings.TrimSpace(out))
+	}
+	return &ChangeRequest{Number: numberFromURL(u), URL: u}, skippedGroups(opts.TeamReviewers)
+}
+
+// skippedGroups reports the teams that were not asked to review: glab only
+// takes user names.
+func skippedGroups(teams []string) error {
+	if len(teams) == 0 {
+		return nil
+	}
+	return fmt.Errorf("glab cannot request groups as reviewers, skipped %s", strings.Join(teams, ", "))
+}
+
+func (g *gitLab) update(ctx context.Context, cr *ChangeRequest, args ...string) error {
+	_, err := g.run(ctx, append([]string{"mr", "update", strconv.Itoa(cr.Number)}, args...)...)
+	return err
+}
+
+func (g *gitLab) UpdateBody(ctx context.Context, cr *ChangeRequest, body string) error {
+	return g.update(ctx, cr, "--description", body)
+}
+
+func (g *gitLab) Comment(ctx context.Context, cr *ChangeRequest, body string) error {
+	_, err := g.run(ctx, "mr", "note", strconv.Itoa(cr.Number), "--message", body)
+	return err
+}
+
+func (g *gitLab) AddLabels(ctx context.Context, cr *ChangeRequest, labels []string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	return g.update(ctx, cr, "--label", strings.Join(labels, ","))
+}
+
+func (g *gitLab) RequestReviewers(ctx context.Context, cr *ChangeRequest, users, teams []string) error {
+	if len(users) > 0 {
+		if err := g.update(ctx, cr, "--reviewer", strings.Join(users, ",")); err != nil {
+			return err
+		}
+	}
+	return skippedGroups(teams)
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func EnsureRepo(ctx context.Context, workdir string) error {
@@ -29,8 +35,59 @@ func DirtyFiles(ctx context.Context, workdir string) (string, error) {
 	return runGit(ctx, workdir, "status", "--short")
 }
 
-func Stash(ctx context.Context, workdir, message string) error {
-	_, err := runGit(ctx, workdir, "stash", "push", "-u", "-m", message)
+// ErrStashConflict is returned by StashPop when the stashed changes could not
+// be applied cleanly. The stash entry is left in place.
+var ErrStashConflict = errors.New("stashed changes conflict with the working tree")
+
+// Stash saves all uncommitted changes, including untracked files, and returns
+// the commit id of the new stash entry.
+func Stash(ctx context.Context, workdir, message string) (string, error) {
+	if _, err := runGit(ctx, workdir, "stash", "push", "-u", "-m", message); err != nil {
+		return "", err
+	}
+	out, err := runGit(ctx, workdir, "rev-parse", "stash@{0}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// StashPop applies and drops the stash entry with the given commit id, even
+// if other entries were pushed on top of it since.
+func StashPop(ctx context.Context, workdir, ref string) error {
+	out, err := runGit(ctx, workdir, "stash", "list", "--format=%gd %H")
+	if err != nil {
+		return err
+	}
+	var name string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == ref {
+			name = fields[0]
+			break
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("stash %s not found", ref)
+	}
+	if _, err := runGit(ctx, workdir, "stash", "pop", name); err != nil {
+		msg := err.Error()
+		if strings.Contains(msg, "CONFLICT") || strings.Contains(msg, "already exists, no checkout") {
+			return fmt.Errorf("%w (kept as %s)", ErrStashConflict, name)
+		}
+		return err
+	}
+	return nil
+}
+
+// WorktreeAdd creates a detached worktree at path checked out at ref.
+func WorktreeAdd(ctx context.Context, workdir, path, ref string) error {
+	_, err := runGit(ctx, workdir, "worktree", "add", "--detach", path, ref)
+	return err
+}
+
+func WorktreeRemove(ctx context.Context, workdir, path string) error {
+	_, err := runGit(ctx, workdir, "worktree", "remove", "--force", path)
 	return err
 }
 
@@ -39,24 +96,38 @@ func Checkout(ctx context.Context, workdir, branch string) error {
 	return err
 }
 
-func PullFFOnly(ctx context.Context, workdir, branch string) error {
-	out, err := runGit(ctx, workdir, "pull", "--ff-only", "origin", branch)
+// Errors returned by PullFFOnly, wrapped with the remote and branch.
+var (
+	ErrNoTrackingInfo    = errors.New("no tracking information for the branch")
+	ErrRemoteRefNotFound = errors.New("branch not found on the remote")
+	ErrDiverged          = errors.New("local branch has diverged from the remote")
+	ErrLocalChanges      = errors.New("local changes would be overwritten")
+)
+
+func PullFFOnly(ctx context.Context, workdir, remote, branch string) error {
+	_, err := runGit(ctx, workdir, "pull", "--ff-only", remote, branch)
 	if err != nil {
-		errStr := err.Error()
-		if strings.Contains(errStr, "There is no tracking information") {
-			return nil
+		if kind := classifyPullError(err.Error()); kind != nil {
+			return fmt.Errorf("git pull --ff-only %s %s: %w", remote, branch, kind)
 		}
-		if strings.Contains(errStr, "fatal: Not possible to fast-forward, aborting") {
-			return errors.New("git pull --ff-only failed: your local branch has diverged from the remote. Please rebase or reset your local branch")
-		}
-		if strings.Contains(errStr, "Please commit your changes or stash them") {
-			return errors.New("git pull --ff-only failed: you have local changes that would be overwritten. Please stash or commit them")
-		}
-		if strings.Contains(errStr, "Couldn't find remote ref") {
-			// No remote branch yet (local-only repo), safe to skip.
-			return nil
-		}
-		return fmt.Errorf("git pull --ff-only failed: %w\nOutput: %s", err, string(out))
+		return fmt.Errorf("git pull --ff-only failed: %w", err)
+	}
+	return nil
+}
+
+func classifyPullError(msg string) error {
+	// Capitalization of these messages differs between git versions.
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "there is no tracking information"):
+		return ErrNoTrackingInfo
+	case strings.Contains(msg, "couldn't find remote ref"):
+		return ErrRemoteRefNotFound
+	case strings.Contains(msg, "not possible to fast-forward"):
+		return ErrDiverged
+	case strings.Contains(msg, "please commit your changes or stash them"),
+		strings.Contains(msg, "would be overwritten by merge"):
+		return ErrLocalChanges
 	}
 	return nil
 }
@@ -66,6 +137,81 @@ func CreateBranch(ctx context.Context, workdir, branch string) error {
 	return err
 }
 
+// CheckBranchName validates name with git check-ref-format --branch.
+func CheckBranchName(ctx context.Context, workdir, name string) error {
+	_, err := runGit(ctx, workdir, "check-ref-format", "--branch", name)
+	if err != nil {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	return nil
+}
+
+func BranchExists(ctx context.Context, workdir, branch string) (bool, error) {
+	return refExists(ctx, workdir, "refs/heads/"+branch)
+}
+
+// RemoteBranchExists asks the remote whether it has branch.
+func RemoteBranchExists(ctx context.Context, workdir, remote, branch string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--exit-code", "--heads", remote, branch)
+	cmd.Dir = workdir
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git ls-remote %s %s: %w\n%s", remote, branch, err, strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// CheckoutRemote fetches branch from remote and checks it out as a local
+// branch tracking it.
+func CheckoutRemote(ctx context.Context, workdir, remote, branch string) error {
+	if _, err := runGit(ctx, workdir, "fetch", remote, "+refs/heads/"+branch+":refs/remotes/"+remote+"/"+branch); err != nil {
+		return err
+	}
+	_, err := runGit(ctx, workdir, "checkout", "-b", branch, "--track", remote+"/"+branch)
+	return err
+}
+
+func refExists(ctx context.Context, workdir, ref string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", ref)
+	cmd.Dir = workdir
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git rev-parse --verify %s: %w", ref, err)
+	}
+	return true, nil
+}
+
+func DeleteBranch(ctx context.Context, workdir, branch string) error {
+	_, err := runGit(ctx, workdir, "branch", "-D", branch)
+	return err
+}
+
+// SetBranch points branch at ref. The branch must not be checked out.
+func SetBranch(ctx context.Context, workdir, branch, ref string) error {
+	_, err := runGit(ctx, workdir, "branch", "-f", branch, ref)
+	return err
+}
+
+// ResetHard discards all changes to tracked files and moves HEAD to ref.
+func ResetHard(ctx context.Context, workdir, ref string) error {
+	_, err := runGit(ctx, workdir, "reset", "--hard", ref)
+	return err
+}
+
+// Clean removes untracked files and directories. Ignored files are kept.
+func Clean(ctx context.Context, workdir string) error {
+	_, err := runGit(ctx, workdir, "clean", "-fd")
+	return err
+}
+
 func CurrentBranch(ctx context.Context, workdir string) (string, error) {
 	out, err := runGit(ctx, workdir, "rev-parse", "--abbrev-ref", "HEAD")
 	if err != nil {
@@ -74,6 +220,27 @@ func CurrentBranch(ctx context.Context, workdir string) (string, error) {
 	return strings.TrimSpace(out), nil
 }
 
+// HeadRef returns the current branch name, or the commit id when HEAD is
+// detached, so that it can be checked out again later.
+func HeadRef(ctx context.Context, workdir string) (string, error) {
+	branch, err := CurrentBranch(ctx, workdir)
+	if err != nil {
+		return "", err
+	}
+	if branch != "HEAD" {
+		return branch, nil
+	}
+	return RevParse(ctx, workdir, "HEAD")
+}
+
+func RevParse(ctx context.Context, workdir, rev string) (string, error) {
+	out, err := runGit(ctx, workdir, "rev-parse", "--verify", rev)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
 func RepoRoot(ctx context.Context, workdir string) (string, error) {
 	out, err := runGit(ctx, workdir, "rev-parse", "--show-toplevel")
 	if err != nil {
@@ -86,18 +253,44 @@ func Diff(ctx context.Context, workdir string) (string, error) {
 	return runGit(ctx, workdir, "diff")
 }
 
+// DiffSince diffs the working tree against ref, covering both commits made
+// since ref and uncommitted changes.
+func DiffSince(ctx context.Context, workdir, ref string) (string, error) {
+	return runGit(ctx, workdir, "diff", ref)
+}
+
+func StagedDiff(ctx context.Context, workdir string) (string, error) {
+	return runGit(ctx, workdir, "diff", "--cached")
+}
+
 func DiffStat(ctx context.Context, workdir string) (string, error) {
 	return runGit(ctx, workdir, "diff", "--stat")
 }
 
+func DiffStatSince(ctx context.Context, workdir, ref string) (string, error) {
+	return runGit(ctx, workdir, "diff", "--stat", ref)
+}
+
 func ChangedFiles(ctx context.Context, workdir string) ([]string, error) {
 	out, err := runGit(ctx, workdir, "diff", "--name-only")
 	if err != nil {
 		return nil, err
 	}
+	return splitLines(out), nil
+}
+
+func ChangedFilesSince(ctx context.Context, workdir, ref string) ([]string, error) {
+	out, err := runGit(ctx, workdir, "diff", "--name-only", ref)
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(out), nil
+}
+
+func splitLines(out string) []string {
 	lines := strings.Split(strings.TrimSpace(out), "\n")
 	if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
-		return nil, nil
+		return nil
 	}
 	result := make([]string, 0, len(lines))
 	for _, line := range lines {
@@ -106,28 +299,449 @@ func ChangedFiles(ctx context.Context, workdir string) ([]string, error) {
 			result = append(result, line)
 		}
 	}
-	return result, nil
+	return result
 }
 
 func RepoTree(ctx context.Context, workdir string) (string, error) {
 	return runGit(ctx, workdir, "ls-tree", "-r", "--name-only", "HEAD")
 }
 
+// ListFiles returns the tracked and untracked, non-ignored files matching
+// any of pathspecs, relative to the repository root.
+func ListFiles(ctx context.Context, workdir string, pathspecs ...string) ([]string, error) {
+	args := append([]string{"ls-files", "--cached", "--others", "--exclude-standard", "--full-name", "--"}, pathspecs...)
+	out, err := runGit(ctx, workdir, args...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, f := range splitLines(out) {
+		// Unmerged files are listed once per stage.
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// UntrackedFiles returns the untracked, non-ignored files, relative to
+// the repository root.
+func UntrackedFiles(ctx context.Context, workdir string) ([]string, error) {
+	out, err := runGit(ctx, workdir, "ls-files", "--others", "--exclude-standard", "--full-name")
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(out), nil
+}
+
+// IsTracked reports whether path, relative to the repository root, is in
+// the index.
+func IsTracked(ctx context.Context, workdir, path string) (bool, error) {
+	out, err := runGit(ctx, workdir, "ls-files", "--cached", "--full-name", "--", ":(top,literal)"+path)
+	if err != nil {
+		return false, err
+	}
+	return len(splitLines(out)) > 0, nil
+}
+
+// SetSkipWorktree sets or clears the skip-worktree bit of tracked paths.
+// While it is set, git ignores changes to the files in the working tree:
+// they don't show in status or diffs and aren't staged by add.
+func SetSkipWorktree(ctx context.Context, workdir string, skip bool, paths ...string) error {
+	flag := "--no-skip-worktree"
+	if skip {
+		flag = "--skip-worktree"
+	}
+	_, err := runGit(ctx, workdir, append([]string{"update-index", flag, "--"}, paths...)...)
+	return err
+}
+
+// IsSkipWorktree reports whether the skip-worktree bit of path, relative
+// to the repository root, is set.
+func IsSkipWorktree(ctx context.Context, workdir, path string) (bool, error) {
+	out, err := runGit(ctx, workdir, "ls-files", "-v", "--full-name", "--", ":(top,literal)"+path)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(out, "S "), nil
+}
+
+// RestoreFiles discards the working tree changes of paths, relative to
+// the repository root.
+func RestoreFiles(ctx context.Context, workdir string, paths ...string) error {
+	args := []string{"checkout", "--"}
+	for _, p := range paths {
+		args = append(args, ":(top,literal)"+p)
+	}
+	_, err := runGit(ctx, workdir, args...)
+	return err
+}
+
+// ExcludeFile returns the path of the repository's info/exclude file,
+// which is shared by all of its worktrees.
+func ExcludeFile(ctx context.Context, workdir string) (string, error) {
+	out, err := runGit(ctx, workdir, "rev-parse", "--git-path", "info/exclude")
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSpace(out)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workdir, path)
+	}
+	return path, nil
+}
+
+// Log returns one line per commit, "hash date subject (author)", newest
+// first: at most n commits, none older than since if it is set, and only
+// those touching paths if any are given.
+func Log(ctx context.Context, workdir string, n int, since time.Time, paths ...string) ([]string, error) {
+	args := []string{"log", fmt.Sprintf("-n%d", n), "--date=short", "--format=%h %ad %s (%an)"}
+	if !since.IsZero() {
+		args = append(args, "--since="+since.Format(time.RFC3339))
+	}
+	if len(paths) > 0 {
+		args = append(append(args, "--"), paths...)
+	}
+	out, err := runGit(ctx, workdir, args...)
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(out), nil
+}
+
+// BlameCommit is a commit that last changed some lines of a file. Lines
+// unchanged since the since date of Blame are attributed to a boundary
+// commit.
+type BlameCommit struct {
+	Hash     string
+	Author   string
+	Summary  string
+	Time     time.Time
+	Lines    int
+	Boundary bool
+}
+
+// Blame summarizes which commits last changed the lines of file at HEAD,
+// most lines first. Changes older than since, if set, are not told apart.
+func Blame(ctx context.Context, workdir, file string, since time.Time) ([]BlameCommit, error) {
+	args := []string{"blame", "--line-porcelain", "--root", "HEAD"}
+	if !since.IsZero() {
+		args = append(args, "--since="+since.Format(time.RFC3339))
+	}
+	out, err := runGit(ctx, workdir, append(args, "--", file)...)
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*BlameCommit)
+	var commits []*BlameCommit
+	var cur *BlameCommit
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if cur != nil {
+				cur.Lines++
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		switch {
+		case (len(key) == 40 || len(key) == 64) && isHex(key):
+			// "<hash> <orig line> <final line> ..." starts each line's headers.
+			c, ok := byHash[key]
+			if !ok {
+				c = &BlameCommit{Hash: key[:7]}
+				byHash[key] = c
+				commits = append(commits, c)
+			}
+			cur = c
+		case cur == nil:
+			continue
+		case key == "author":
+			cur.Author = value
+		case key == "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				cur.Time = time.Unix(sec, 0)
+			}
+		case key == "summary":
+			cur.Summary = value
+		case key == "boundary":
+			cur.Boundary = true
+		}
+	}
+	sort.SliceStable(commits, func(i, j int) bool { return commits[i].Lines > commits[j].Lines })
+	result := make([]BlameCommit, 0, len(commits))
+	for _, c := range commits {
+		result = append(result, *c)
+	}
+	return result, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
 func AddAll(ctx context.Context, workdir string) error {
 	_, err := runGit(ctx, workdir, "add", "-A")
 	return err
 }
 
-func Commit(ctx context.Context, workdir, message string) error {
-	_, err := runGit(ctx, workdir, "commit", "-m", message)
+// HasStagedChanges reports whether the index differs from HEAD.
+func HasStagedChanges(ctx context.Context, workdir string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", "--cached", "--quiet")
+	cmd.Dir = workdir
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git diff --cached --quiet: %w", err)
+	}
+	return false, nil
+}
+
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// CommitOptions overrides the identity and signing settings git would
+// otherwise take from the environment. Zero values keep git's defaults.
+// SignFormat is a gpg.format value: openpgp, ssh or x509.
+type CommitOptions struct {
+	Author      Identity
+	Committer   Identity
+	Sign        bool
+	SignFormat  string
+	SigningKey  string
+	SignProgram string
+}
+
+func Commit(ctx context.Context, workdir, message string, opts CommitOptions) error {
+	args, env := opts.gitArgs()
+	args = append(args, "commit", "-m", message)
+	if opts.Sign {
+		args = append(args, "--gpg-sign")
+	}
+	_, err := runGitEnv(ctx, workdir, env, args...)
+	return err
+}
+
+// gitArgs returns the -c options and environment that apply opts to any
+// git command that creates commits.
+func (opts CommitOptions) gitArgs() ([]string, []string) {
+	var args []string
+	if opts.Sign {
+		if opts.SignFormat != "" {
+			args = append(args, "-c", "gpg.format="+opts.SignFormat)
+		}
+		if opts.SigningKey != "" {
+			args = append(args, "-c", "user.signingkey="+opts.SigningKey)
+		}
+		if opts.SignProgram != "" {
+			key := "gpg.program"
+			if opts.SignFormat == "ssh" || opts.SignFormat == "x509" {
+				key = "gpg." + opts.SignFormat + ".program"
+			}
+			args = append(args, "-c", key+"="+opts.SignProgram)
+		}
+	}
+	var env []string
+	if opts.Author.Name != "" {
+		env = append(env, "GIT_AUTHOR_NAME="+opts.Author.Name, "GIT_AUTHOR_EMAIL="+opts.Author.Email)
+	}
+	if opts.Committer.Name != "" {
+		env = append(env, "GIT_COMMITTER_NAME="+opts.Committer.Name, "GIT_COMMITTER_EMAIL="+opts.Committer.Email)
+	}
+	return args, env
+}
+
+// ErrRebaseConflict is returned by Rebase and RebaseContinue when git stops
+// on conflicting changes. The rebase is left in progress.
+var ErrRebaseConflict = errors.New("rebase stopped on conflicts")
+
+func Fetch(ctx context.Context, workdir, remote, branch string) error {
+	_, err := runGit(ctx, workdir, "fetch", remote, branch)
+	if err != nil {
+		if kind := classifyPullError(err.Error()); kind != nil {
+			return fmt.Errorf("git fetch %s %s: %w", remote, branch, kind)
+		}
+	}
+	return err
+}
+
+// CountCommits returns the number of commits reachable from to but not
+// from from.
+func CountCommits(ctx context.Context, workdir, from, to string) (int, error) {
+	out, err := runGit(ctx, workdir, "rev-list", "--count", from+".."+to)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+func IsAncestor(ctx context.Context, workdir, ancestor, rev string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "merge-base", "--is-ancestor", ancestor, rev)
+	cmd.Dir = workdir
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("git merge-base --is-ancestor %s %s: %w", ancestor, rev, err)
+	}
+	return true, nil
+}
+
+func MergeBase(ctx context.Context, workdir, a, b string) (string, error) {
+	out, err := runGit(ctx, workdir, "merge-base", a, b)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Rebase replays the current branch onto upstream. Rewritten commits keep
+// their author; opts sets the committer and signing.
+func Rebase(ctx context.Context, workdir, upstream string, opts CommitOptions) error {
+	args, env := opts.gitArgs()
+	args = append(args, "rebase")
+	if opts.Sign {
+		args = append(args, "--gpg-sign")
+	}
+	args = append(args, upstream)
+	return rebaseStep(ctx, workdir, env, args)
+}
+
+func RebaseContinue(ctx context.Context, workdir string, opts CommitOptions) error {
+	args, env := opts.gitArgs()
+	args = append(args, "rebase", "--continue")
+	return rebaseStep(ctx, workdir, env, args)
+}
+
+func RebaseAbort(ctx context.Context, workdir string) error {
+	_, err := runGit(ctx, workdir, "rebase", "--abort")
+	return err
+}
+
+func rebaseStep(ctx context.Context, workdir string, env, args []string) error {
+	// Never open an editor for commit messages while continuing.
+	env = append(env, "GIT_EDITOR=true")
+	_, err := runGitEnv(ctx, workdir, env, args...)
+	if err == nil {
+		return nil
+	}
+	if files, ferr := ConflictedFiles(ctx, workdir); ferr == nil && len(files) > 0 {
+		return fmt.Errorf("%w: %s", ErrRebaseConflict, strings.Join(files, ", "))
+	}
 	return err
 }
 
-func Push(ctx context.Context, workdir, branch string) error {
-	_, err := runGit(ctx, workdir, "push", "-u", "origin", branch)
+func ConflictedFiles(ctx context.Context, workdir string) ([]string, error) {
+	out, err := runGit(ctx, workdir, "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+	return splitLines(out), nil
+}
+
+// UserIdentity returns the user.name and user.email git would use in
+// workdir.
+func UserIdentity(ctx context.Context, workdir string) (Identity, error) {
+	name, err := runGit(ctx, workdir, "config", "user.name")
+	if err != nil {
+		return Identity{}, err
+	}
+	email, err := runGit(ctx, workdir, "config", "user.email")
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Name: strings.TrimSpace(name), Email: strings.TrimSpace(email)}, nil
+}
+
+func Push(ctx context.Context, workdir, remote, branch string) error {
+	_, err := runGit(ctx, workdir, "push", "-u", remote, branch)
 	return err
 }
 
+// ForcePush pushes a rewritten branch, refusing to overwrite commits on the
+// remote that were not fetched first.
+func ForcePush(ctx context.Context, workdir, remote, branch string) error {
+	_, err := runGit(ctx, workdir, "push", "-u", "--force-with-lease", remote, branch)
+	return err
+}
+
+func DeleteRemoteBranch(ctx context.Context, workdir, remote, branch string) error {
+	_, err := runGit(ctx, workdir, "push", remote, "--delete", branch)
+	return err
+}
+
+// PushRefTo force-pushes ref to branch on remote, refusing to overwrite
+// commits on the remote that were not fetched first.
+func PushRefTo(ctx context.Context, workdir, remote, ref, branch string) error {
+	_, err := runGit(ctx, workdir, "push", "--force-with-lease", remote, ref+":refs/heads/"+branch)
+	return err
+}
+
+// HasRemote reports whether remote is configured in the repo.
+func HasRemote(ctx context.Context, workdir, remote string) (bool, error) {
+	out, err := runGit(ctx, workdir, "remote")
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(splitLines(out), remote), nil
+}
+
+func RemoteURL(ctx context.Context, workdir, remote string) (string, error) {
+	out, err := runGit(ctx, workdir, "remote", "get-url", remote)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// RemoteRepo identifies a repository on a forge. Owner may contain slashes
+// for nested groups.
+type RemoteRepo struct {
+	Host  string
+	Owner string
+	Name  string
+}
+
+func (r RemoteRepo) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+// ParseRemoteURL understands https://, ssh:// and scp-like
+// (git@host:owner/repo.git) remote URLs.
+func ParseRemoteURL(raw string) (RemoteRepo, error) {
+	raw = strings.TrimSpace(raw)
+	var host, path string
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if at, rest, ok := strings.Cut(raw, ":"); ok && !strings.Contains(at, "/") {
+		host, path = at, rest
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+	} else {
+		return RemoteRepo{}, fmt.Errorf("unsupported remote URL %q", raw)
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	i := strings.LastIndex(path, "/")
+	if host == "" || i <= 0 || i == len(path)-1 {
+		return RemoteRepo{}, fmt.Errorf("unsupported remote URL %q", raw)
+	}
+	return RemoteRepo{Host: host, Owner: path[:i], Name: path[i+1:]}, nil
+}
+
 func DiffLineCount(diff string) int {
 	var count int
 	for _, line := range strings.Split(diff, "\n") {
@@ -142,8 +756,15 @@ func DiffLineCount(diff string) int {
 }
 
 func runGit(ctx context.Context, workdir string, args ...string) (string, error) {
+	return runGitEnv(ctx, workdir, nil, args...)
+}
+
+func runGitEnv(ctx context.Context, workdir string, env []string, args ...string) (string, error) {
 	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = workdir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("git %s: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
@@ -1,6 +1,9 @@
 package gitutil
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestDiffLineCount(t *testing.T) {
 	diff := `diff --git a/a.txt b/a.txt
@@ -17,3 +20,33 @@ index 123..456 100644
 		t.Fatalf("expected 2 changed lines, got %d", got)
 	}
 }
+
+func TestClassifyPullError(t *testing.T) {
+	msg := "git pull --ff-only origin main: exit status 128\nfatal: Couldn't find remote ref main"
+	if err := classifyPullError(msg); !errors.Is(err, ErrRemoteRefNotFound) {
+		t.Fatalf("expected ErrRemoteRefNotFound, got %v", err)
+	}
+	if err := classifyPullError("fatal: unable to access remote"); err != nil {
+		t.Fatalf("expected unclassified error, got %v", err)
+	}
+}
+
+func TestParseRemoteURL(t *testing.T) {
+	cases := map[string]RemoteRepo{
+		"git@github.com:acme/api.git":                 {Host: "github.com", Owner: "acme", Name: "api"},
+		"https://gitlab.example.com/group/sub/web":    {Host: "gitlab.example.com", Owner: "group/sub", Name: "web"},
+		"ssh://git@git.example.com:2222/team/svc.git": {Host: "git.example.com", Owner: "team", Name: "svc"},
+	}
+	for raw, want := range cases {
+		got, err := ParseRemoteURL(raw)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", raw, err)
+		}
+		if got != want {
+			t.Fatalf("%s: want %+v, got %+v", raw, want, got)
+		}
+	}
+	if _, err := ParseRemoteURL("/srv/git/api.git"); err == nil {
+		t.Fatal("expected error for local path remote")
+	}
+}
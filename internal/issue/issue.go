@@ -0,0 +1,86 @@
+// Package issue reads tasks written as markdown issues.
+package issue
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Issue is a task with its optional metadata.
+type Issue struct {
+	Title      string   `yaml:"title"`
+	Labels     []string `yaml:"labels"`
+	Acceptance []string `yaml:"acceptance_criteria"`
+	Body       string   `yaml:"-"`
+}
+
+var (
+	heading  = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*\s*$`)
+	listItem = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+(?:\[[ xX]\]\s+)?(.*\S)\s*$`)
+)
+
+// Parse reads an issue: optional YAML frontmatter between "---" lines
+// followed by a markdown body. Without a title in the frontmatter, a
+// leading "# " heading is the title. Acceptance criteria may also be listed
+// under an "Acceptance criteria" heading, which is then cut from the body.
+func Parse(text string) (*Issue, error) {
+	var iss Issue
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		front, body, found := strings.Cut(rest, "\n---\n")
+		if !found {
+			front, found = strings.CutSuffix(rest, "\n---")
+		}
+		if !found {
+			return nil, fmt.Errorf("frontmatter is not closed by a --- line")
+		}
+		if err := yaml.Unmarshal([]byte(front), &iss); err != nil {
+			return nil, fmt.Errorf("parse frontmatter: %w", err)
+		}
+		text = body
+	}
+
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if iss.Title == "" && len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		iss.Title = strings.TrimSpace(strings.TrimPrefix(lines[0], "# "))
+		lines = lines[1:]
+	}
+
+	var body []string
+	inCriteria := false
+	for _, line := range lines {
+		if m := heading.FindStringSubmatch(line); m != nil {
+			inCriteria = strings.EqualFold(strings.TrimSuffix(m[1], ":"), "acceptance criteria")
+			if inCriteria {
+				continue
+			}
+		}
+		if !inCriteria {
+			body = append(body, line)
+			continue
+		}
+		if m := listItem.FindStringSubmatch(line); m != nil {
+			iss.Acceptance = append(iss.Acceptance, m[1])
+		}
+	}
+	iss.Title = strings.TrimSpace(iss.Title)
+	iss.Body = strings.TrimSpace(strings.Join(body, "\n"))
+	if iss.Title == "" && iss.Body == "" {
+		return nil, fmt.Errorf("issue has neither a title nor a description")
+	}
+	return &iss, nil
+}
+
+// Task returns the task handed to agents: the title and the description.
+func (i *Issue) Task() string {
+	switch {
+	case i.Title == "":
+		return i.Body
+	case i.Body == "":
+		return i.Title
+	}
+	return i.Title + "\n\n" + i.Body
+}
@@ -0,0 +1,72 @@
+package issue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFrontmatter(t *testing.T) {
+	iss, err := Parse(`---
+title: Add a status column to users
+labels: [backend, db]
+acceptance_criteria:
+  - Existing rows default to "active"
+  - The API returns the status
+---
+Users need a status so support can suspend accounts.
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Issue{
+		Title:      "Add a status column to users",
+		Labels:     []string{"backend", "db"},
+		Acceptance: []string{`Existing rows default to "active"`, "The API returns the status"},
+		Body:       "Users need a status so support can suspend accounts.",
+	}
+	if !reflect.DeepEqual(iss, want) {
+		t.Fatalf("want %+v, got %+v", want, iss)
+	}
+	if got := iss.Task(); got != "Add a status column to users\n\nUsers need a status so support can suspend accounts." {
+		t.Fatalf("unexpected task %q", got)
+	}
+}
+
+func TestParseMarkdownSections(t *testing.T) {
+	iss, err := Parse(`# Fix login redirect
+
+Users land on / after logging in.
+
+## Acceptance criteria
+- [ ] Users return to the page they came from
+- [x] Open redirects are rejected
+
+## Notes
+See the auth middleware.
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iss.Title != "Fix login redirect" {
+		t.Errorf("title = %q", iss.Title)
+	}
+	if want := []string{"Users return to the page they came from", "Open redirects are rejected"}; !reflect.DeepEqual(iss.Acceptance, want) {
+		t.Errorf("acceptance = %q", iss.Acceptance)
+	}
+	if want := "Users land on / after logging in.\n\n## Notes\nSee the auth middleware."; iss.Body != want {
+		t.Errorf("body = %q", iss.Body)
+	}
+}
+
+func TestParsePlainText(t *testing.T) {
+	iss, err := Parse("rename the config flag\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iss.Title != "" || iss.Task() != "rename the config flag" {
+		t.Fatalf("unexpected issue %+v", iss)
+	}
+	if _, err := Parse("---\ntitle: x\n"); err == nil {
+		t.Fatal("expected an error for unclosed frontmatter")
+	}
+}
@@ -1,23 +1,46 @@
 package prompt
 
 import (
+	"bytes"
+	"embed"
 	"fmt"
 	"os"
 	"strings"
+	"text/template"
 
 	"github.com/threatlevelmidnight10/devspec/internal/spec"
 )
 
+// Repo is a repository of the workspace, as described to agents.
+type Repo struct {
+	Name        string
+	Root        string // absolute path
+	Description string
+}
+
 type Inputs struct {
 	Spec          *spec.Spec
+	Repos         []Repo
 	Task          string
+	Acceptance    []string // acceptance criteria of the task
 	PlannerPrompt string
 	ImplPrompt    string
+	CommitPrompt  string
+	CommitPattern string
+	ResolvePrompt string
+	SummaryPrompt string
+	ConflictFiles []string
+	ConflictDiff  string
 	Skills        []string
 	RepoTree      string
+	Files         []string // "==> repo: path" followed by the file's contents
+	Symbols       string
+	GitLog        string
+	FileHistory   string
 	GitDiff       string
 	PlanOutput    string
 	DiffOutput    string
+	Feedback      string
 }
 
 func LoadFiles(paths []string) ([]string, error) {
@@ -32,84 +55,64 @@ func LoadFiles(paths []string) ([]string, error) {
 	return out, nil
 }
 
-func BuildPlan(in Inputs) string {
-	return strings.TrimSpace(fmt.Sprintf(`%s
-
-%s
-
-%s
-
-TASK:
-%s
-
-STRICT MODE:
-- Do not modify files.
-- Output a structured plan only with sections: Steps, Files, Risks.
-
-CONSTRAINTS:
-- max_iterations: %d
-- max_diff_lines: %d
-- require_tests: %t
-`,
-		header("PLANNER SYSTEM PROMPT", in.PlannerPrompt),
-		header("SKILLS", joinBlocks(in.Skills)),
-		sharedContext(in),
-		in.Task,
-		in.Spec.Constraints.MaxIterations,
-		in.Spec.Constraints.MaxDiffLines,
-		in.Spec.Constraints.RequireTests,
-	))
-}
-
-func BuildImplement(in Inputs) string {
-	return strings.TrimSpace(fmt.Sprintf(`%s
-
-%s
-
-%s
-
-PLAN OUTPUT:
-%s
+// Prompt kinds. Each has an embedded default template in templates/ that a
+// spec can override.
+const (
+	KindPlan      = "plan"
+	KindImplement = "implement"
+	KindReview    = "review"
+	KindCommit    = "commit"
+	KindResolve   = "resolve"
+	KindSummary   = "summary"
+)
 
-TASK:
-%s
+//go:embed templates/*.tmpl
+var defaults embed.FS
 
-CONSTRAINTS:
-- Max diff lines: %d
-- Tests required: %t
-- CRITICAL: You are running inside a virtual workspace across multiple repositories. You MUST use EXACT ABSOLUTE PATHS when editing or reading files. Do NOT use relative paths like 'backend/main.go', use the full absolute path as shown in the REPO TREE.
-`,
-		header("IMPLEMENTER SYSTEM PROMPT", in.ImplPrompt),
-		header("SKILLS", joinBlocks(in.Skills)),
-		sharedContext(in),
-		in.PlanOutput,
-		in.Task,
-		in.Spec.Constraints.MaxDiffLines,
-		in.Spec.Constraints.RequireTests,
-	))
+// Default returns the embedded template for kind.
+func Default(kind string) (string, error) {
+	b, err := defaults.ReadFile("templates/" + kind + ".tmpl")
+	if err != nil {
+		return "", fmt.Errorf("unknown prompt kind %q", kind)
+	}
+	return string(b), nil
 }
 
-func BuildSelfReview(in Inputs) string {
-	return strings.TrimSpace(fmt.Sprintf(`%s
-
-%s
-
-Review the current changes critically and fix issues found.
+var funcs = template.FuncMap{
+	"section":   header,
+	"bullets":   bulletList,
+	"checklist": checklist,
+	"blocks":    joinBlocks,
+	"context":   sharedContext,
+	"feedback":  feedbackSection,
+	"workspace": workspaceSection,
+}
 
-DIFF:
-%s
+// Parse parses a prompt template. Templates are rendered with Inputs and
+// can call section, bullets, checklist, blocks, context, workspace and
+// feedback.
+func Parse(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
 
-CHECKLIST:
-- Tests exist when required.
-- Migration safety.
-- Backward compatibility.
-- Constraint compliance.
-- CRITICAL: Use EXACT ABSOLUTE PATHS for any further file modifications.
-`,
-		header("IMPLEMENTER SYSTEM PROMPT", in.ImplPrompt),
-		header("SKILLS", joinBlocks(in.Skills)),
-		in.DiffOutput,
-	))
+// Render renders the prompt of the given kind. A non-empty tmpl replaces
+// the embedded default.
+func Render(kind, tmpl string, in Inputs) (string, error) {
+	if tmpl == "" {
+		var err error
+		if tmpl, err = Default(kind); err != nil {
+			return "", err
+		}
+	}
+	t, err := Parse(kind, tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse %s prompt template: %w", kind, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, in); err != nil {
+		return "", fmt.Errorf("render %s prompt template: %w", kind, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
 }
 
 func sharedContext(in Inputs) string {
@@ -117,6 +120,18 @@ func sharedContext(in Inputs) string {
 	if strings.TrimSpace(in.RepoTree) != "" {
 		parts = append(parts, header("REPO TREE", in.RepoTree))
 	}
+	if strings.TrimSpace(in.Symbols) != "" {
+		parts = append(parts, header("SYMBOLS", in.Symbols))
+	}
+	if len(in.Files) > 0 {
+		parts = append(parts, header("FILES", strings.Join(in.Files, "\n\n")))
+	}
+	if strings.TrimSpace(in.GitLog) != "" {
+		parts = append(parts, header("RECENT COMMITS", in.GitLog))
+	}
+	if strings.TrimSpace(in.FileHistory) != "" {
+		parts = append(parts, header("HISTORY OF PLANNED FILES", in.FileHistory))
+	}
 	if strings.TrimSpace(in.GitDiff) != "" {
 		parts = append(parts, header("CURRENT GIT DIFF", in.GitDiff))
 	}
@@ -126,6 +141,44 @@ func sharedContext(in Inputs) string {
 	return strings.Join(parts, "\n\n")
 }
 
+// workspaceSection tells agents where the repos are, followed by a blank
+// line: the root of a single repo, or a table of the repos of a multi-repo
+// workspace. It is empty when there are no repos.
+func workspaceSection(in Inputs) string {
+	switch len(in.Repos) {
+	case 0:
+		return ""
+	case 1:
+		repo := in.Repos[0]
+		text := "Repository root: " + repo.Root
+		if repo.Description != "" {
+			text += "\nRole: " + repo.Description
+		}
+		text += "\nPaths in the repo tree and diffs are relative to this root."
+		return header("WORKSPACE", text) + "\n\n"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "The workspace spans %d repositories. Paths in the repo tree and diffs are relative to each repo's root; use the absolute path (root + path) when reading or editing files.\n\n", len(in.Repos))
+	b.WriteString("| Repo | Root | Role |\n|------|------|------|")
+	for _, repo := range in.Repos {
+		role := repo.Description
+		if role == "" {
+			role = "-"
+		}
+		fmt.Fprintf(&b, "\n| %s | %s | %s |", repo.Name, repo.Root, role)
+	}
+	return header("WORKSPACE", b.String()) + "\n\n"
+}
+
+// feedbackSection returns the review feedback block, followed by a blank
+// line, or nothing when there is no feedback.
+func feedbackSection(in Inputs) string {
+	if strings.TrimSpace(in.Feedback) == "" {
+		return ""
+	}
+	return header("REVIEW FEEDBACK (address every point)", strings.TrimSpace(in.Feedback)) + "\n\n"
+}
+
 func header(title, body string) string {
 	if strings.TrimSpace(body) == "" {
 		body = "(none)"
@@ -133,6 +186,20 @@ func header(title, body string) string {
 	return fmt.Sprintf("%s:\n%s", title, body)
 }
 
+func bulletList(items []string) string {
+	if len(items) == 0 {
+		return "(none)"
+	}
+	return "- " + strings.Join(items, "\n- ")
+}
+
+func checklist(items []string) string {
+	if len(items) == 0 {
+		return "(none)"
+	}
+	return "- [ ] " + strings.Join(items, "\n- [ ] ")
+}
+
 func joinBlocks(items []string) string {
 	if len(items) == 0 {
 		return "(none)"
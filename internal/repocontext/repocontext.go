@@ -0,0 +1,297 @@
+// Package repocontext fits the context handed to agents — skills, files, the
+// repo tree, symbol outlines, git history and diffs — into a token budget.
+package repocontext
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EstimateTokens estimates the number of tokens in s. Code and English
+// average about four bytes per token, which is close enough for budgeting.
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+type Skill struct {
+	Name string
+	Body string
+}
+
+// File is a file inlined into the context.
+type File struct {
+	Name string
+	Body string
+}
+
+// Sections is the packable part of a prompt.
+type Sections struct {
+	Skills  []Skill // in priority order
+	Files   []File  // in priority order
+	Tree    string  // git ls-tree output; "==> repo:" lines start a repo
+	Symbols string  // symbol outline; "==> repo:" lines start a repo
+	Diff    string  // unified diff; "==> repo:" lines start a repo
+	GitLog  string  // recent commits; "==> repo:" lines start a repo
+	History string  // log and blame of files; "==> repo: path" lines start a file
+}
+
+func (s Sections) tokens() int {
+	n := EstimateTokens(s.Tree) + EstimateTokens(s.Symbols) + EstimateTokens(s.Diff) +
+		EstimateTokens(s.GitLog) + EstimateTokens(s.History)
+	for _, sk := range s.Skills {
+		n += EstimateTokens(sk.Body)
+	}
+	for _, f := range s.Files {
+		n += EstimateTokens(f.Body)
+	}
+	return n
+}
+
+// Report describes what Pack trimmed.
+type Report struct {
+	Budget  int
+	Before  int
+	After   int
+	Trimmed []string
+}
+
+func (r Report) String() string {
+	if len(r.Trimmed) == 0 {
+		return fmt.Sprintf("context fits in %d tokens (%d used)", r.Budget, r.Before)
+	}
+	return fmt.Sprintf("context trimmed from ~%d to ~%d tokens (budget %d):\n  - %s",
+		r.Before, r.After, r.Budget, strings.Join(r.Trimmed, "\n  - "))
+}
+
+// Pack trims s to fit in budget tokens. Skills come first, in order, and
+// may use up to half the budget; files come next and may use up to half of
+// what is left. A skill or file that doesn't fit is dropped whole. The rest
+// is shared fairly between the tree, the symbols, the diff, the git log and
+// the file history: the tree is collapsed into per-directory summaries, the
+// diff truncated file by file and the others cut short.
+func Pack(budget int, s Sections) (Sections, Report) {
+	rep := Report{Budget: budget, Before: s.tokens()}
+	if rep.Before <= budget {
+		rep.After = rep.Before
+		return s, rep
+	}
+
+	var out Sections
+	used := 0
+	for _, sk := range s.Skills {
+		n := EstimateTokens(sk.Body)
+		if used+n > budget/2 {
+			rep.Trimmed = append(rep.Trimmed, fmt.Sprintf("skill %s dropped (~%d tokens)", sk.Name, n))
+			continue
+		}
+		used += n
+		out.Skills = append(out.Skills, sk)
+	}
+
+	filesUsed := 0
+	for _, f := range s.Files {
+		n := EstimateTokens(f.Body)
+		if filesUsed+n > (budget-used)/2 {
+			rep.Trimmed = append(rep.Trimmed, fmt.Sprintf("file %s dropped (~%d tokens)", f.Name, n))
+			continue
+		}
+		filesUsed += n
+		out.Files = append(out.Files, f)
+	}
+	used += filesUsed
+
+	allot := fairShares(budget-used, []int{
+		EstimateTokens(s.Tree), EstimateTokens(s.Symbols), EstimateTokens(s.Diff),
+		EstimateTokens(s.GitLog), EstimateTokens(s.History),
+	})
+	treeBudget, symbolsBudget, diffBudget, logBudget, historyBudget := allot[0], allot[1], allot[2], allot[3], allot[4]
+
+	var note string
+	out.Tree, note = CollapseTree(s.Tree, treeBudget)
+	if note != "" {
+		rep.Trimmed = append(rep.Trimmed, note)
+	}
+	if out.Symbols = s.Symbols; EstimateTokens(s.Symbols) > symbolsBudget {
+		out.Symbols = cutLines(strings.Split(s.Symbols, "\n"), symbolsBudget)
+		rep.Trimmed = append(rep.Trimmed, "symbol outline shortened")
+	}
+	var truncated []string
+	out.Diff, truncated = TruncateDiff(s.Diff, diffBudget)
+	if len(truncated) > 0 {
+		rep.Trimmed = append(rep.Trimmed, "diff truncated in "+strings.Join(truncated, ", "))
+	}
+	if out.GitLog = s.GitLog; EstimateTokens(s.GitLog) > logBudget {
+		out.GitLog = cutLines(strings.Split(s.GitLog, "\n"), logBudget)
+		rep.Trimmed = append(rep.Trimmed, "git log shortened")
+	}
+	if out.History = s.History; EstimateTokens(s.History) > historyBudget {
+		out.History = cutLines(strings.Split(s.History, "\n"), historyBudget)
+		rep.Trimmed = append(rep.Trimmed, "file history shortened")
+	}
+	rep.After = out.tokens()
+	return out, rep
+}
+
+// CollapseTree shortens a file listing to fit in budget tokens by replacing
+// the files below a directory with a "dir/ (N files)" summary, from the
+// deepest directories up. It returns a note on what was collapsed, or ""
+// if the tree fit.
+func CollapseTree(tree string, budget int) (string, string) {
+	if EstimateTokens(tree) <= budget {
+		return tree, ""
+	}
+	lines := strings.Split(strings.TrimRight(tree, "\n"), "\n")
+	maxDepth := 0
+	for _, l := range lines {
+		maxDepth = max(maxDepth, strings.Count(l, "/"))
+	}
+	var out string
+	for depth := maxDepth - 1; depth >= 0; depth-- {
+		out = collapseAt(lines, depth)
+		if EstimateTokens(out) <= budget {
+			return out, fmt.Sprintf("repo tree collapsed to directories %d levels deep", depth+1)
+		}
+	}
+	// Even top-level summaries don't fit: keep what does.
+	return cutLines(strings.Split(out, "\n"), budget), "repo tree cut to top-level directories and shortened"
+}
+
+// cutLines keeps the leading lines that fit in budget tokens, followed by a
+// note of how many were cut.
+func cutLines(lines []string, budget int) string {
+	var b strings.Builder
+	for i, l := range lines {
+		marker := fmt.Sprintf("... (%d more entries)", len(lines)-i)
+		if EstimateTokens(b.String()+l+"\n"+marker) > budget {
+			b.WriteString(marker)
+			break
+		}
+		b.WriteString(l + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// fairShares splits budget between needs: each gets an equal share, and
+// those needing less than theirs leave the rest to the others.
+func fairShares(budget int, needs []int) []int {
+	order := make([]int, len(needs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return needs[order[a]] < needs[order[b]] })
+	allot := make([]int, len(needs))
+	for n, i := range order {
+		share := max(budget, 0) / (len(needs) - n)
+		allot[i] = min(needs[i], share)
+		budget -= allot[i]
+	}
+	return allot
+}
+
+// collapseAt summarizes every path nested more than depth directories deep
+// under its ancestor at that depth. Directories holding a single file keep
+// the file's path.
+func collapseAt(lines []string, depth int) string {
+	var out []string
+	counts := make(map[string]int)
+	index := make(map[string]int)
+	for _, l := range lines {
+		if strings.HasPrefix(l, "==> ") {
+			// A new repo: directories of different repos are not merged.
+			clear(counts)
+			clear(index)
+			out = append(out, l)
+			continue
+		}
+		parts := strings.Split(l, "/")
+		if len(parts)-1 <= depth {
+			out = append(out, l)
+			continue
+		}
+		dir := strings.Join(parts[:depth+1], "/") + "/"
+		if _, ok := index[dir]; !ok {
+			index[dir] = len(out)
+			out = append(out, l)
+		}
+		counts[dir]++
+		if counts[dir] > 1 {
+			out[index[dir]] = fmt.Sprintf("%s (%d files)", dir, counts[dir])
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// diffChunk is the diff of one file, or a "==> repo:" header line.
+type diffChunk struct {
+	name  string
+	lines []string
+}
+
+// TruncateDiff shortens a unified diff to fit in budget tokens. Each file
+// gets a fair share of the budget — files smaller than their share keep
+// everything and leave the rest to others — and files over their share are
+// cut with a marker. It returns the names of the truncated files.
+func TruncateDiff(diff string, budget int) (string, []string) {
+	if EstimateTokens(diff) <= budget {
+		return diff, nil
+	}
+	var chunks []diffChunk
+	for _, l := range strings.Split(strings.TrimRight(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			name := l[strings.LastIndex(l, " b/")+3:]
+			chunks = append(chunks, diffChunk{name: name, lines: []string{l}})
+		case strings.HasPrefix(l, "==> ") || len(chunks) == 0:
+			chunks = append(chunks, diffChunk{lines: []string{l}})
+		default:
+			c := &chunks[len(chunks)-1]
+			c.lines = append(c.lines, l)
+		}
+	}
+
+	// Repo headers are always kept; files share what is left.
+	remaining := budget
+	var files []int
+	need := make([]int, len(chunks))
+	for i, c := range chunks {
+		need[i] = EstimateTokens(strings.Join(c.lines, "\n") + "\n")
+		if c.name == "" {
+			remaining -= need[i]
+		} else {
+			files = append(files, i)
+		}
+	}
+	fileNeeds := make([]int, len(files))
+	for n, i := range files {
+		fileNeeds[n] = need[i]
+	}
+	allot := make([]int, len(chunks))
+	for n, a := range fairShares(remaining, fileNeeds) {
+		allot[files[n]] = a
+	}
+
+	var b strings.Builder
+	var truncated []string
+	for i, c := range chunks {
+		if c.name == "" || allot[i] >= need[i] {
+			b.WriteString(strings.Join(c.lines, "\n") + "\n")
+			continue
+		}
+		truncated = append(truncated, c.name)
+		kept := 0
+		size := 0
+		for _, l := range c.lines {
+			n := EstimateTokens(l + "\n")
+			// Keep room for the marker.
+			if kept > 0 && size+n+16 > allot[i] {
+				break
+			}
+			size += n
+			kept++
+			b.WriteString(l + "\n")
+		}
+		fmt.Fprintf(&b, "[... devspec: %d more lines of %s truncated ...]\n", len(c.lines)-kept, c.name)
+	}
+	return strings.TrimRight(b.String(), "\n"), truncated
+}
@@ -0,0 +1,128 @@
+package repocontext
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCollapseTree(t *testing.T) {
+	var lines []string
+	lines = append(lines, "==> api:", "go.mod", "main.go")
+	for i := range 40 {
+		lines = append(lines, fmt.Sprintf("internal/store/file%02d.go", i))
+	}
+	lines = append(lines, "internal/api/handler.go")
+	tree := strings.Join(lines, "\n")
+
+	got, note := CollapseTree(tree, 30)
+	want := "==> api:\ngo.mod\nmain.go\ninternal/store/ (40 files)\ninternal/api/handler.go"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	if note == "" {
+		t.Fatal("expected a note")
+	}
+
+	got, _ = CollapseTree(tree, 12)
+	want = "==> api:\ngo.mod\nmain.go\ninternal/ (41 files)"
+	if got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	if got, note := CollapseTree(tree, 10000); got != tree || note != "" {
+		t.Fatal("tree within budget should be unchanged")
+	}
+}
+
+func fileDiff(name string, n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n@@ -1,0 +1,%d @@\n", name, name, name, name, n)
+	for i := range n {
+		fmt.Fprintf(&b, "+line %d of %s\n", i, name)
+	}
+	return b.String()
+}
+
+func TestTruncateDiffSharesBudget(t *testing.T) {
+	diff := "==> api:\n" + fileDiff("small.go", 2) + fileDiff("big.go", 400)
+	got, truncated := TruncateDiff(diff, 300)
+
+	if len(truncated) != 1 || truncated[0] != "big.go" {
+		t.Fatalf("want only big.go truncated, got %v", truncated)
+	}
+	if !strings.Contains(got, fileDiff("small.go", 2)) {
+		t.Error("small file should be kept whole")
+	}
+	if !strings.Contains(got, "more lines of big.go truncated") {
+		t.Errorf("missing truncation marker:\n%s", got)
+	}
+	if n := EstimateTokens(got); n > 300 {
+		t.Errorf("truncated diff uses %d tokens, budget 300", n)
+	}
+}
+
+func TestPackDropsLowPrioritySkills(t *testing.T) {
+	in := Sections{
+		Skills: []Skill{
+			{Name: "style", Body: strings.Repeat("s", 200)},
+			{Name: "huge", Body: strings.Repeat("h", 2000)},
+			{Name: "tests", Body: strings.Repeat("t", 100)},
+		},
+		Diff: fileDiff("main.go", 200),
+	}
+	out, rep := Pack(400, in)
+	var names []string
+	for _, sk := range out.Skills {
+		names = append(names, sk.Name)
+	}
+	if strings.Join(names, ",") != "style,tests" {
+		t.Fatalf("want style,tests kept, got %v", names)
+	}
+	if rep.After > 400 || len(rep.Trimmed) != 2 {
+		t.Fatalf("unexpected report: %s", rep)
+	}
+}
+
+func TestPackDropsFilesOverTheirShare(t *testing.T) {
+	in := Sections{
+		Files: []File{
+			{Name: "api: docs/architecture.md", Body: strings.Repeat("a", 400)},
+			{Name: "api: api/big.proto", Body: strings.Repeat("b", 4000)},
+			{Name: "api: api/small.proto", Body: strings.Repeat("c", 200)},
+		},
+		Tree: strings.Repeat("main.go\n", 200),
+	}
+	out, rep := Pack(600, in)
+	var names []string
+	for _, f := range out.Files {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "api: docs/architecture.md,api: api/small.proto" {
+		t.Fatalf("want the big file dropped, got %v", names)
+	}
+	if rep.After > 600 {
+		t.Fatalf("packed context over budget: %s", rep)
+	}
+}
+
+func TestPackBudgetsGitHistory(t *testing.T) {
+	in := Sections{
+		Tree:    strings.Repeat("main.go\n", 50),
+		GitLog:  "==> api:\n" + strings.Repeat("abc1234 2026-10-01 Fix nil user (Alice)\n", 100),
+		History: "==> api: users.go\nlog:\n" + strings.Repeat("  abc1234 2026-10-01 Fix nil user (Alice)\n", 100),
+	}
+	out, rep := Pack(1000, in)
+	if rep.Before <= 2000 {
+		t.Fatalf("git log and file history should be counted, got ~%d tokens", rep.Before)
+	}
+	if rep.After > 1000 || out.tokens() > 1000 {
+		t.Fatalf("packed context over budget: %s", rep)
+	}
+	if !strings.Contains(rep.String(), "git log shortened") || !strings.Contains(rep.String(), "file history shortened") {
+		t.Errorf("unexpected report: %s", rep)
+	}
+	if out.Tree != in.Tree {
+		t.Errorf("the tree fits its share and should be kept")
+	}
+}
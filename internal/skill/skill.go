@@ -0,0 +1,94 @@
+// Package skill reads skills: guidance for agents whose frontmatter can
+// scope them to some steps, repos and paths.
+package skill
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/threatlevelmidnight10/devspec/internal/codeowners"
+	"gopkg.in/yaml.v3"
+)
+
+// Skill is a skill and the scope its frontmatter gives it. Steps and Kinds
+// name the steps and prompt kinds it applies to, Repos and Paths the files
+// it concerns.
+type Skill struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Steps       []string `yaml:"steps"`
+	Kinds       []string `yaml:"kinds"`
+	Repos       []string `yaml:"repos"`
+	Paths       []string `yaml:"paths"`
+	Body        string   `yaml:"-"`
+
+	patterns []*regexp.Regexp
+}
+
+// Parse reads a skill: optional YAML frontmatter between "---" lines
+// followed by its text. Paths are .gitignore-style patterns, as in
+// CODEOWNERS.
+func Parse(text string) (*Skill, error) {
+	var s Skill
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		front, body, found := strings.Cut(rest, "\n---\n")
+		if !found {
+			front, found = strings.CutSuffix(rest, "\n---")
+		}
+		if !found {
+			return nil, fmt.Errorf("frontmatter is not closed by a --- line")
+		}
+		if err := yaml.Unmarshal([]byte(front), &s); err != nil {
+			return nil, fmt.Errorf("parse frontmatter: %w", err)
+		}
+		text = body
+	}
+	for _, p := range s.Paths {
+		re, err := codeowners.Compile(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("paths: %w", err)
+		}
+		s.patterns = append(s.patterns, re)
+	}
+	s.Name = strings.TrimSpace(s.Name)
+	s.Body = strings.TrimSpace(text)
+	if s.Body == "" {
+		return nil, fmt.Errorf("skill has no content")
+	}
+	return &s, nil
+}
+
+// ForStep reports whether the skill applies to the step of the given name
+// and prompt kind: always when it names neither steps nor kinds, else when
+// it names the step or its kind.
+func (s *Skill) ForStep(step, kind string) bool {
+	if len(s.Steps) == 0 && len(s.Kinds) == 0 {
+		return true
+	}
+	return slices.Contains(s.Steps, step) || slices.Contains(s.Kinds, kind)
+}
+
+// Scoped reports whether the skill only concerns some repos or paths.
+func (s *Skill) Scoped() bool {
+	return len(s.Repos) > 0 || len(s.Paths) > 0
+}
+
+// Concerns reports whether the skill concerns path, slash-separated and
+// relative to the root of repo.
+func (s *Skill) Concerns(repo, path string) bool {
+	if len(s.Repos) > 0 && !slices.Contains(s.Repos, repo) {
+		return false
+	}
+	if len(s.patterns) == 0 {
+		return true
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package skill
+
+import "testing"
+
+func TestParseScopedSkill(t *testing.T) {
+	s, err := Parse(`---
+name: migrations
+description: How we write database migrations
+kinds: [implement, review]
+repos: [api]
+paths: ["db/migrations/", "*.sql"]
+---
+
+Never edit a migration that has been released.
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "migrations" || s.Body != "Never edit a migration that has been released." {
+		t.Fatalf("unexpected skill: %+v", s)
+	}
+	if s.ForStep("plan", "plan") || !s.ForStep("self_review", "review") {
+		t.Error("skill should apply to implement and review prompts only")
+	}
+	for _, c := range []struct {
+		repo, path string
+		want       bool
+	}{
+		{"api", "db/migrations/0042_add_email.go", true},
+		{"api", "internal/store/queries.sql", true},
+		{"api", "internal/store/users.go", false},
+		{"web", "db/migrations/0001_init.go", false},
+	} {
+		if got := s.Concerns(c.repo, c.path); got != c.want {
+			t.Errorf("Concerns(%q, %q) = %v, want %v", c.repo, c.path, got, c.want)
+		}
+	}
+}
+
+func TestParsePlainSkill(t *testing.T) {
+	s, err := Parse("Keep changes small and reviewable.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Scoped() || !s.ForStep("plan", "plan") || s.Body != "Keep changes small and reviewable." {
+		t.Fatalf("unexpected skill: %+v", s)
+	}
+
+	if _, err := Parse("---\nname: x\n"); err == nil {
+		t.Error("expected an error for unclosed frontmatter")
+	}
+	if _, err := Parse("---\nname: x\n---\n"); err == nil {
+		t.Error("expected an error for a skill without content")
+	}
+}
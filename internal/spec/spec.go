@@ -1,12 +1,18 @@
 package spec
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"text/template"
 
+	"github.com/threatlevelmidnight10/devspec/internal/symbols"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,55 +23,154 @@ var allowedStepModes = map[string]struct{}{
 	"ask":   {},
 }
 
+var allowedForges = map[string]struct{}{
+	"":        {},
+	"github":  {},
+	"gitlab":  {},
+	"gitea":   {},
+	"forgejo": {},
+}
+
+// RuleTargets lists where rules can be written.
+var RuleTargets = []string{"cursor", "agents", "claude"}
+
+// PromptKinds lists the prompts that can be overridden in prompts.
+var PromptKinds = []string{"plan", "implement", "review", "commit", "resolve", "summary"}
+
 type Spec struct {
-	Version     string           `yaml:"version" json:"version"`
-	Name        string           `yaml:"name" json:"name"`
-	Description string           `yaml:"description" json:"description"`
-	Model       string           `yaml:"model" json:"model"`
-	Workspace   Workspace        `yaml:"workspace" json:"workspace"`
-	Context     Context          `yaml:"context" json:"context"`
-	Agents      map[string]Agent `yaml:"agents" json:"agents"`
-	Skills      []string         `yaml:"skills" json:"skills"`
-	Steps       []Step           `yaml:"steps" json:"steps"`
-	Constraints Constraints      `yaml:"constraints" json:"constraints"`
-	Output      Output           `yaml:"output" json:"output"`
-	Binary      string           `yaml:"binary" json:"binary"`
-	SourcePath  string           `yaml:"-" json:"-"`
-	SourceDir   string           `yaml:"-" json:"-"`
+	Version     string            `yaml:"version" json:"version"`
+	Name        string            `yaml:"name" json:"name"`
+	Description string            `yaml:"description" json:"description"`
+	Model       string            `yaml:"model" json:"model"`
+	Workspace   Workspace         `yaml:"workspace" json:"workspace"`
+	Context     Context           `yaml:"context" json:"context"`
+	Agents      map[string]Agent  `yaml:"agents" json:"agents"`
+	Skills      []string          `yaml:"skills" json:"skills"`
+	Rules       []Rule            `yaml:"rules" json:"rules"`
+	Prompts     map[string]string `yaml:"prompts" json:"prompts"`
+	Steps       []Step            `yaml:"steps" json:"steps"`
+	Constraints Constraints       `yaml:"constraints" json:"constraints"`
+	Output      Output            `yaml:"output" json:"output"`
+	Commit      Commit            `yaml:"commit" json:"commit"`
+	Binary      string            `yaml:"binary" json:"binary"`
+	SourcePath  string            `yaml:"-" json:"-"`
+	SourceDir   string            `yaml:"-" json:"-"`
+	SourceHash  string            `yaml:"-" json:"-"`
 }
 
 type Workspace struct {
-	BaseBranch string     `yaml:"base_branch" json:"base_branch"`
-	BranchPref string     `yaml:"branch_prefix" json:"branch_prefix"`
-	AutoCommit bool       `yaml:"auto_commit" json:"auto_commit"`
-	Repos      []RepoSpec `yaml:"repos" json:"repos"`
+	BaseBranch     string     `yaml:"base_branch" json:"base_branch"`
+	BranchPref     string     `yaml:"branch_prefix" json:"branch_prefix"`
+	BranchTemplate string     `yaml:"branch_template" json:"branch_template"`
+	TicketPattern  string     `yaml:"ticket_pattern" json:"ticket_pattern"`
+	AutoCommit     bool       `yaml:"auto_commit" json:"auto_commit"`
+	Atomic         bool       `yaml:"atomic" json:"atomic"`
+	Repos          []RepoSpec `yaml:"repos" json:"repos"`
+	Rebase         Rebase     `yaml:"rebase" json:"rebase"`
+}
+
+// Rebase configures rebasing the agent branch onto the latest base branch
+// before pushing. Agent, if set, resolves conflicts; MaxRounds bounds how
+// many times it is called per repo.
+type Rebase struct {
+	Enabled   bool   `yaml:"enabled" json:"enabled"`
+	Agent     string `yaml:"agent" json:"agent"`
+	MaxRounds int    `yaml:"max_rounds" json:"max_rounds"`
 }
 
+// RepoSpec describes one repo of the workspace. Remote is where the base
+// branch comes from and pull requests are opened; PushRemote, e.g. a fork,
+// is where the agent branch is pushed. DependsOn names repos whose pull
+// requests must be merged first. Forge overrides output.forge for this
+// repo. Description tells agents what the repo is for.
 type RepoSpec struct {
-	Name       string `yaml:"name" json:"name"`
-	Path       string `yaml:"path" json:"path"`
-	BaseBranch string `yaml:"base_branch" json:"base_branch"`
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description" json:"description"`
+	Path        string   `yaml:"path" json:"path"`
+	BaseBranch  string   `yaml:"base_branch" json:"base_branch"`
+	Remote      string   `yaml:"remote" json:"remote"`
+	PushRemote  string   `yaml:"push_remote" json:"push_remote"`
+	DependsOn   []string `yaml:"depends_on" json:"depends_on"`
+	Forge       string   `yaml:"forge" json:"forge"`
 }
 
+// Context configures what agents are given besides the task. MaxTokens, if
+// set, is an estimated token budget for skills, files, the repo tree, symbol
+// outlines and diffs. Files are git glob pathspecs whose contents are
+// inlined, each cut to MaxFileBytes. Symbols names a symbol provider.
+// GitLog adds commit history.
 type Context struct {
-	IncludeRepoTree bool `yaml:"include_repo_tree" json:"include_repo_tree"`
-	IncludeGitDiff  bool `yaml:"include_git_diff" json:"include_git_diff"`
-	IncludeUntrack  bool `yaml:"include_untracked" json:"include_untracked"`
+	IncludeRepoTree  bool     `yaml:"include_repo_tree" json:"include_repo_tree"`
+	IncludeGitDiff   bool     `yaml:"include_git_diff" json:"include_git_diff"`
+	IncludeUntrack   bool     `yaml:"include_untracked" json:"include_untracked"`
+	MaxTokens        int      `yaml:"max_tokens" json:"max_tokens"`
+	Files            []string `yaml:"files" json:"files"`
+	IncludePlanFiles bool     `yaml:"include_plan_files" json:"include_plan_files"`
+	MaxFileBytes     int      `yaml:"max_file_bytes" json:"max_file_bytes"`
+	Symbols          string   `yaml:"symbols" json:"symbols"`
+	GitLog           GitLog   `yaml:"git_log" json:"git_log"`
+}
+
+// GitLog configures the commit history given to agents: the subjects of
+// the last Commits commits of each repo and, with PlanFiles, the log and
+// blame summary of the files the plan names. MaxAgeDays, if set, leaves
+// out older commits.
+type GitLog struct {
+	Commits     int  `yaml:"commits" json:"commits"`
+	PlanFiles   bool `yaml:"plan_files" json:"plan_files"`
+	FileCommits int  `yaml:"file_commits" json:"file_commits"`
+	MaxAgeDays  int  `yaml:"max_age_days" json:"max_age_days"`
 }
 
+// DefaultMaxFileBytes is the default size limit of each context file.
+const DefaultMaxFileBytes = 32 * 1024
+
 type Agent struct {
 	Prompt   string `yaml:"prompt" json:"prompt"`
 	Model    string `yaml:"model" json:"model"`
 	ReadOnly bool   `yaml:"read_only" json:"read_only"`
 }
 
+// Rule is written into every repo for the length of a run and removed
+// before anything is committed or pushed. Content is inline text or a file
+// path, like skills. Target is where it goes: cursor writes
+// .cursor/rules/<name>.mdc with Description and Globs as its frontmatter;
+// agents and claude append to AGENTS.md and CLAUDE.md. It defaults to
+// DefaultRuleTarget.
+type Rule struct {
+	Name        string   `yaml:"name" json:"name"`
+	Content     string   `yaml:"content" json:"content"`
+	Target      string   `yaml:"target" json:"target"`
+	Description string   `yaml:"description" json:"description"`
+	Globs       []string `yaml:"globs" json:"globs"`
+}
+
+// DefaultRuleTarget returns where rules go by default: where the agent
+// runtime the steps run with, named by binary, reads them. Claude Code
+// reads CLAUDE.md and the Cursor agent CLI .cursor/rules; AGENTS.md is
+// the convention most other agent CLIs follow.
+func (s *Spec) DefaultRuleTarget() string {
+	switch strings.TrimSuffix(filepath.Base(s.Binary), ".exe") {
+	case ".", "agent", "cursor-agent":
+		return "cursor"
+	case "claude":
+		return "claude"
+	}
+	return "agents"
+}
+
+var ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Step is one step of the workflow. PromptTemplate overrides the prompt
+// template of an agent step, taking precedence over prompts.
 type Step struct {
-	Name         string `yaml:"name" json:"name"`
-	Agent        string `yaml:"agent" json:"agent"`
-	Mode         string `yaml:"mode" json:"mode"`
-	Run          string `yaml:"run" json:"run"`
-	AllowFailure bool   `yaml:"allow_failure" json:"allow_failure"`
-	Retry        int    `yaml:"retry" json:"retry"`
+	Name           string `yaml:"name" json:"name"`
+	Agent          string `yaml:"agent" json:"agent"`
+	Mode           string `yaml:"mode" json:"mode"`
+	Run            string `yaml:"run" json:"run"`
+	AllowFailure   bool   `yaml:"allow_failure" json:"allow_failure"`
+	Retry          int    `yaml:"retry" json:"retry"`
+	PromptTemplate string `yaml:"prompt_template" json:"prompt_template"`
 }
 
 type Constraints struct {
@@ -74,9 +179,145 @@ type Constraints struct {
 	RequireTests  bool `yaml:"require_tests" json:"require_tests"`
 }
 
+// Output configures the change requests opened for the agent branch.
+// PRTemplate is a text/template rendered with the run's results;
+// SummaryAgent, if set, writes its Summary. Forge is github, gitlab, gitea
+// or forgejo; when empty it is detected from the remote URL.
 type Output struct {
-	CreatePR   bool   `yaml:"create_pr" json:"create_pr"`
-	PRTemplate string `yaml:"pr_template" json:"pr_template"`
+	CreatePR     bool   `yaml:"create_pr" json:"create_pr"`
+	PRTemplate   string `yaml:"pr_template" json:"pr_template"`
+	SummaryAgent string `yaml:"summary_agent" json:"summary_agent"`
+	PR           PR     `yaml:"pr" json:"pr"`
+	Forge        string `yaml:"forge" json:"forge"`
+	Gitea        Gitea  `yaml:"gitea" json:"gitea"`
+}
+
+// DefaultPRTitle is used when output.pr.title is empty.
+const DefaultPRTitle = "devspec: {{.Title}}"
+
+// PR is the metadata of opened pull requests. Title is a text/template
+// with the same fields as the pr_template. CodeOwners adds the CODEOWNERS
+// owners of the changed files to Reviewers.
+type PR struct {
+	Title      string   `yaml:"title" json:"title"`
+	Draft      bool     `yaml:"draft" json:"draft"`
+	Labels     []string `yaml:"labels" json:"labels"`
+	Reviewers  []string `yaml:"reviewers" json:"reviewers"`
+	Assignees  []string `yaml:"assignees" json:"assignees"`
+	Milestone  string   `yaml:"milestone" json:"milestone"`
+	CodeOwners bool     `yaml:"codeowners" json:"codeowners"`
+}
+
+// Gitea configures the REST API used for the gitea and forgejo forges. URL
+// defaults to https://<remote host>.
+type Gitea struct {
+	URL      string `yaml:"url" json:"url"`
+	TokenEnv string `yaml:"token_env" json:"token_env"`
+}
+
+// DefaultCommitPattern accepts a Conventional Commits subject line.
+const DefaultCommitPattern = `^(build|chore|ci|docs|feat|fix|perf|refactor|revert|style|test)(\([\w./-]+\))?!?: \S.*$`
+
+// Commit configures the commits devspec makes on the agent branch. Message
+// is either a text/template or "agent: <name>"; Devspec-* trailers are
+// always appended after it.
+type Commit struct {
+	PerStep         bool              `yaml:"per_step" json:"per_step"`
+	Message         string            `yaml:"message" json:"message"`
+	Pattern         string            `yaml:"pattern" json:"pattern"`
+	Trailers        map[string]string `yaml:"trailers" json:"trailers"`
+	Author          Identity          `yaml:"author" json:"author"`
+	Committer       Identity          `yaml:"committer" json:"committer"`
+	CoAuthors       []string          `yaml:"co_authors" json:"co_authors"`
+	CoAuthorGitUser bool              `yaml:"co_author_git_user" json:"co_author_git_user"`
+	Sign            Signing           `yaml:"sign" json:"sign"`
+}
+
+type Identity struct {
+	Name  string `yaml:"name" json:"name"`
+	Email string `yaml:"email" json:"email"`
+}
+
+func (i Identity) IsZero() bool {
+	return strings.TrimSpace(i.Name) == "" && strings.TrimSpace(i.Email) == ""
+}
+
+func (i Identity) String() string {
+	return fmt.Sprintf("%s <%s>", i.Name, i.Email)
+}
+
+func (i Identity) validate(field string) error {
+	if i.IsZero() {
+		return nil
+	}
+	if strings.TrimSpace(i.Name) == "" || strings.TrimSpace(i.Email) == "" {
+		return fmt.Errorf("%s needs both name and email", field)
+	}
+	return nil
+}
+
+// Signing configures commit signing. Format is one of gpg, ssh or x509 and
+// Key is passed to git as user.signingkey.
+type Signing struct {
+	Format  string `yaml:"format" json:"format"`
+	Key     string `yaml:"key" json:"key"`
+	Program string `yaml:"program" json:"program"`
+}
+
+func (sg Signing) Enabled() bool {
+	return strings.TrimSpace(sg.Format) != "" || strings.TrimSpace(sg.Key) != ""
+}
+
+func (sg Signing) validate(field string) error {
+	switch strings.TrimSpace(sg.Format) {
+	case "", "gpg", "ssh", "x509":
+	default:
+		return fmt.Errorf("%s.format %q is invalid; allowed: gpg, ssh, x509", field, sg.Format)
+	}
+	if strings.TrimSpace(sg.Format) == "ssh" && strings.TrimSpace(sg.Key) == "" {
+		return fmt.Errorf("%s.key is required for ssh signing", field)
+	}
+	return nil
+}
+
+func (c Commit) validateIdentity(field string) error {
+	if err := c.Author.validate(field + ".author"); err != nil {
+		return err
+	}
+	if err := c.Committer.validate(field + ".committer"); err != nil {
+		return err
+	}
+	for _, co := range c.CoAuthors {
+		if !coAuthorPattern.MatchString(strings.TrimSpace(co)) {
+			return fmt.Errorf("%s.co_authors entry %q must look like \"Name <email>\"", field, co)
+		}
+	}
+	return c.Sign.validate(field + ".sign")
+}
+
+var coAuthorPattern = regexp.MustCompile(`^[^<>]+ <[^<>\s]+@[^<>\s]+>$`)
+
+// MessageAgent returns the agent named by a message of the form
+// "agent: <name>".
+func (c Commit) MessageAgent() (string, bool) {
+	name, ok := strings.CutPrefix(strings.TrimSpace(c.Message), "agent:")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(name), true
+}
+
+// EffectivePattern returns the pattern commit subjects must match, or "" if
+// they are not validated. Agent-written messages default to Conventional
+// Commits.
+func (c Commit) EffectivePattern() string {
+	if strings.TrimSpace(c.Pattern) != "" {
+		return c.Pattern
+	}
+	if _, ok := c.MessageAgent(); ok {
+		return DefaultCommitPattern
+	}
+	return ""
 }
 
 func Load(path string) (*Spec, error) {
@@ -96,6 +337,8 @@ func Load(path string) (*Spec, error) {
 	}
 	s.SourcePath = abs
 	s.SourceDir = filepath.Dir(abs)
+	sum := sha256.Sum256(b)
+	s.SourceHash = hex.EncodeToString(sum[:])
 
 	applyDefaults(&s)
 	if err := s.Validate(); err != nil {
@@ -114,18 +357,47 @@ func applyDefaults(s *Spec) {
 	if s.Workspace.BranchPref == "" {
 		s.Workspace.BranchPref = "agent/"
 	}
+	if s.Workspace.TicketPattern == "" {
+		s.Workspace.TicketPattern = `[A-Z][A-Z0-9]+-[0-9]+`
+	}
 	if len(s.Workspace.Repos) == 0 {
 		s.Workspace.Repos = []RepoSpec{
 			{Name: "default", Path: ".", BaseBranch: s.Workspace.BaseBranch},
 		}
-	} else {
-		for i := range s.Workspace.Repos {
-			if s.Workspace.Repos[i].BaseBranch == "" {
-				s.Workspace.Repos[i].BaseBranch = s.Workspace.BaseBranch
-			}
+	}
+	for i := range s.Workspace.Repos {
+		repo := &s.Workspace.Repos[i]
+		if repo.BaseBranch == "" {
+			repo.BaseBranch = s.Workspace.BaseBranch
+		}
+		if repo.Remote == "" {
+			repo.Remote = "origin"
+		}
+		if repo.PushRemote == "" {
+			repo.PushRemote = repo.Remote
+		}
+	}
+	if strings.TrimSpace(s.Output.PR.Title) == "" {
+		s.Output.PR.Title = DefaultPRTitle
+	}
+	if s.Output.Gitea.TokenEnv == "" {
+		s.Output.Gitea.TokenEnv = "GITEA_TOKEN"
+	}
+	if s.Workspace.Rebase.MaxRounds == 0 {
+		s.Workspace.Rebase.MaxRounds = 3
+	}
+	for i := range s.Rules {
+		if s.Rules[i].Target == "" {
+			s.Rules[i].Target = s.DefaultRuleTarget()
 		}
 	}
 	s.Context.IncludeRepoTree = true
+	if s.Context.MaxFileBytes == 0 {
+		s.Context.MaxFileBytes = DefaultMaxFileBytes
+	}
+	if s.Context.GitLog.FileCommits == 0 {
+		s.Context.GitLog.FileCommits = 5
+	}
 	if s.Constraints.MaxIterations == 0 {
 		s.Constraints.MaxIterations = 5
 	}
@@ -165,6 +437,9 @@ func (s *Spec) Validate() error {
 		if hasRun && strings.TrimSpace(step.Mode) != "" {
 			return fmt.Errorf("steps[%d].mode is only valid for agent steps", i)
 		}
+		if hasRun && strings.TrimSpace(step.PromptTemplate) != "" {
+			return fmt.Errorf("steps[%d].prompt_template is only valid for agent steps", i)
+		}
 		if hasAgent {
 			ag, ok := s.Agents[step.Agent]
 			if !ok {
@@ -175,6 +450,34 @@ func (s *Spec) Validate() error {
 			}
 		}
 	}
+	for kind, path := range s.Prompts {
+		if !slices.Contains(PromptKinds, kind) {
+			return fmt.Errorf("prompts.%s is not a prompt kind; allowed: %s", kind, strings.Join(PromptKinds, ", "))
+		}
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("prompts.%s must be a template path", kind)
+		}
+	}
+	if err := s.validateRules(); err != nil {
+		return err
+	}
+	if s.Context.MaxTokens < 0 {
+		return errors.New("context.max_tokens cannot be negative")
+	}
+	if s.Context.MaxFileBytes < 0 {
+		return errors.New("context.max_file_bytes cannot be negative")
+	}
+	if gl := s.Context.GitLog; gl.Commits < 0 || gl.FileCommits < 0 || gl.MaxAgeDays < 0 {
+		return errors.New("context.git_log counts cannot be negative")
+	}
+	if sym := s.Context.Symbols; sym != "" && !slices.Contains(symbols.Names(), sym) {
+		return fmt.Errorf("context.symbols %q is invalid; allowed: %s", sym, strings.Join(symbols.Names(), ", "))
+	}
+	for i, pattern := range s.Context.Files {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("context.files[%d] is empty", i)
+		}
+	}
 	if s.Constraints.MaxIterations <= 0 {
 		return errors.New("constraints.max_iterations must be > 0")
 	}
@@ -184,9 +487,215 @@ func (s *Spec) Validate() error {
 	if s.Output.CreatePR && strings.TrimSpace(s.Output.PRTemplate) == "" {
 		return errors.New("output.pr_template is required when output.create_pr is true")
 	}
+	if name := strings.TrimSpace(s.Output.SummaryAgent); name != "" {
+		ag, ok := s.Agents[name]
+		if !ok {
+			return fmt.Errorf("output.summary_agent %q is not defined in agents", name)
+		}
+		if strings.TrimSpace(ag.Prompt) == "" {
+			return fmt.Errorf("agents.%s.prompt is required", name)
+		}
+	}
+	if _, err := template.New("title").Parse(s.Output.PR.Title); err != nil {
+		return fmt.Errorf("output.pr.title: %w", err)
+	}
+	if _, ok := allowedForges[s.Output.Forge]; !ok {
+		return fmt.Errorf("output.forge %q is invalid; allowed: github, gitlab, gitea, forgejo, or empty", s.Output.Forge)
+	}
+	for _, repo := range s.Workspace.Repos {
+		if _, ok := allowedForges[repo.Forge]; !ok {
+			return fmt.Errorf("workspace.repos.%s.forge %q is invalid; allowed: github, gitlab, gitea, forgejo, or empty", repo.Name, repo.Forge)
+		}
+	}
+	if _, err := s.Workspace.MergeOrder(); err != nil {
+		return err
+	}
+	if _, err := template.New("branch").Parse(s.Workspace.BranchTemplate); err != nil {
+		return fmt.Errorf("workspace.branch_template: %w", err)
+	}
+	if _, err := regexp.Compile(s.Workspace.TicketPattern); err != nil {
+		return fmt.Errorf("workspace.ticket_pattern: %w", err)
+	}
+	if s.Workspace.Rebase.Enabled {
+		if !s.Workspace.AutoCommit {
+			return errors.New("workspace.rebase requires workspace.auto_commit")
+		}
+		if s.Workspace.Rebase.MaxRounds < 0 {
+			return errors.New("workspace.rebase.max_rounds cannot be negative")
+		}
+		if name := strings.TrimSpace(s.Workspace.Rebase.Agent); name != "" {
+			ag, ok := s.Agents[name]
+			if !ok {
+				return fmt.Errorf("workspace.rebase.agent %q is not defined in agents", name)
+			}
+			if strings.TrimSpace(ag.Prompt) == "" {
+				return fmt.Errorf("agents.%s.prompt is required", name)
+			}
+		}
+	}
+	if s.Commit.PerStep && !s.Workspace.AutoCommit {
+		return errors.New("commit.per_step requires workspace.auto_commit")
+	}
+	if name, ok := s.Commit.MessageAgent(); ok {
+		ag, exists := s.Agents[name]
+		if !exists {
+			return fmt.Errorf("commit.message agent %q is not defined in agents", name)
+		}
+		if strings.TrimSpace(ag.Prompt) == "" {
+			return fmt.Errorf("agents.%s.prompt is required", name)
+		}
+	} else if _, err := template.New("commit").Parse(s.Commit.Message); err != nil {
+		return fmt.Errorf("commit.message: %w", err)
+	}
+	if _, err := regexp.Compile(s.Commit.Pattern); err != nil {
+		return fmt.Errorf("commit.pattern: %w", err)
+	}
+	if err := s.Commit.validateIdentity("commit"); err != nil {
+		return err
+	}
+	for key := range s.Commit.Trailers {
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, ": \n") {
+			return fmt.Errorf("commit.trailers key %q is invalid", key)
+		}
+	}
+	return nil
+}
+
+func (s *Spec) validateRules() error {
+	names := make(map[string]bool, len(s.Rules))
+	for i, rule := range s.Rules {
+		if !ruleNamePattern.MatchString(rule.Name) {
+			return fmt.Errorf("rules[%d].name %q is invalid; use letters, digits, '.', '_' and '-'", i, rule.Name)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rules[%d].name %q is used twice", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if strings.TrimSpace(rule.Content) == "" {
+			return fmt.Errorf("rules[%d].content is required", i)
+		}
+		target := rule.Target
+		if target == "" {
+			target = s.DefaultRuleTarget()
+		}
+		if !slices.Contains(RuleTargets, target) {
+			return fmt.Errorf("rules[%d].target %q is invalid; allowed: %s", i, rule.Target, strings.Join(RuleTargets, ", "))
+		}
+		if target != "cursor" && (rule.Description != "" || len(rule.Globs) > 0) {
+			return fmt.Errorf("rules[%d]: description and globs are only valid for cursor rules, not %s", i, target)
+		}
+	}
 	return nil
 }
 
+// MergeOrder returns repo names ordered so that every repo comes after the
+// repos it depends on, keeping the spec order otherwise. It returns nil if
+// no repo declares dependencies.
+func (w Workspace) MergeOrder() ([]string, error) {
+	known := make(map[string]bool, len(w.Repos))
+	for _, repo := range w.Repos {
+		known[repo.Name] = true
+	}
+	var any bool
+	for _, repo := range w.Repos {
+		for _, dep := range repo.DependsOn {
+			if !known[dep] {
+				return nil, fmt.Errorf("workspace.repos.%s.depends_on: unknown repo %q", repo.Name, dep)
+			}
+			if dep == repo.Name {
+				return nil, fmt.Errorf("workspace.repos.%s.depends_on: repo cannot depend on itself", repo.Name)
+			}
+			any = true
+		}
+	}
+	if !any {
+		return nil, nil
+	}
+
+	placed := make(map[string]bool, len(w.Repos))
+	order := make([]string, 0, len(w.Repos))
+	for len(order) < len(w.Repos) {
+		progressed := false
+		for _, repo := range w.Repos {
+			if placed[repo.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range repo.DependsOn {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[repo.Name] = true
+				order = append(order, repo.Name)
+				progressed = true
+				break
+			}
+		}
+		if !progressed {
+			return nil, errors.New("workspace.repos.depends_on contains a cycle")
+		}
+	}
+	return order, nil
+}
+
+// GlobalConfig holds per-user defaults that apply to every spec, read from
+// $DEVSPEC_CONFIG or <user config dir>/devspec/config.yaml.
+type GlobalConfig struct {
+	Commit Commit `yaml:"commit" json:"commit"`
+}
+
+func LoadGlobal() (*GlobalConfig, error) {
+	path := os.Getenv("DEVSPEC_CONFIG")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return &GlobalConfig{}, nil
+		}
+		path = filepath.Join(dir, "devspec", "config.yaml")
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &GlobalConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read global config: %w", err)
+	}
+	var g GlobalConfig
+	if err := yaml.Unmarshal(b, &g); err != nil {
+		return nil, fmt.Errorf("decode global config %s: %w", path, err)
+	}
+	if err := g.Commit.validateIdentity(path + ": commit"); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ApplyGlobal fills commit identity and signing settings the spec leaves
+// empty from the global config.
+func (s *Spec) ApplyGlobal(g *GlobalConfig) {
+	if g == nil {
+		return
+	}
+	if s.Commit.Author.IsZero() {
+		s.Commit.Author = g.Commit.Author
+	}
+	if s.Commit.Committer.IsZero() {
+		s.Commit.Committer = g.Commit.Committer
+	}
+	if len(s.Commit.CoAuthors) == 0 {
+		s.Commit.CoAuthors = g.Commit.CoAuthors
+	}
+	if !s.Commit.CoAuthorGitUser {
+		s.Commit.CoAuthorGitUser = g.Commit.CoAuthorGitUser
+	}
+	if !s.Commit.Sign.Enabled() {
+		s.Commit.Sign = g.Commit.Sign
+	}
+}
+
 func (s *Spec) EffectiveModel(override string) string {
 	if override != "" {
 		return override
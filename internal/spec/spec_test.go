@@ -1,6 +1,7 @@
 package spec
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -68,3 +69,105 @@ func TestSpecParsing(t *testing.T) {
 		t.Fatalf("expected 3 steps, got %d", len(s.Steps))
 	}
 }
+
+func TestApplyGlobalFillsMissingCommitIdentity(t *testing.T) {
+	s := Spec{Commit: Commit{Author: Identity{Name: "spec-bot", Email: "spec@example.com"}}}
+	s.ApplyGlobal(&GlobalConfig{Commit: Commit{
+		Author:    Identity{Name: "global-bot", Email: "global@example.com"},
+		Committer: Identity{Name: "ci", Email: "ci@example.com"},
+		Sign:      Signing{Format: "ssh", Key: "~/.ssh/bot.pub"},
+	}})
+	if s.Commit.Author.Name != "spec-bot" {
+		t.Fatalf("spec author should win, got %q", s.Commit.Author)
+	}
+	if s.Commit.Committer.Name != "ci" || s.Commit.Sign.Format != "ssh" {
+		t.Fatalf("expected committer and signing from global config, got %+v", s.Commit)
+	}
+}
+
+func TestMergeOrderFollowsDependencies(t *testing.T) {
+	w := Workspace{Repos: []RepoSpec{
+		{Name: "frontend", DependsOn: []string{"backend"}},
+		{Name: "backend", DependsOn: []string{"schema"}},
+		{Name: "schema"},
+		{Name: "docs"},
+	}}
+	got, err := w.MergeOrder()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"schema", "backend", "frontend", "docs"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	w.Repos[2].DependsOn = []string{"frontend"}
+	if _, err := w.MergeOrder(); err == nil {
+		t.Fatal("expected cycle error")
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	s := Spec{
+		Version:     "0.1",
+		Name:        "x",
+		Model:       "m",
+		Steps:       []Step{{Name: "test", Run: "go test ./..."}},
+		Constraints: Constraints{MaxIterations: 5, MaxDiffLines: 100},
+		Rules: []Rule{
+			{Name: "go-style", Content: "rules/go.md", Globs: []string{"**/*.go"}},
+			{Name: "agents", Content: "Run make test.", Target: "agents"},
+		},
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, bad := range []Rule{
+		{Name: "../up", Content: "x"},
+		{Name: "go-style", Content: "x"},
+		{Name: "y", Content: "x", Target: "windsurf"},
+		{Name: "y", Content: "x", Target: "claude", Globs: []string{"*.go"}},
+	} {
+		s.Rules = append(s.Rules[:2:2], bad)
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+}
+
+func TestDefaultRuleTargetFollowsBinary(t *testing.T) {
+	for binary, want := range map[string]string{
+		"":                          "cursor",
+		"agent":                     "cursor",
+		"/home/me/.local/bin/agent": "cursor",
+		"claude":                    "claude",
+		"/usr/local/bin/codex":      "agents",
+	} {
+		s := Spec{Binary: binary}
+		if got := s.DefaultRuleTarget(); got != want {
+			t.Errorf("binary %q: want %s, got %s", binary, want, got)
+		}
+	}
+
+	s := Spec{
+		Version:     "0.1",
+		Name:        "x",
+		Model:       "m",
+		Binary:      "claude",
+		Steps:       []Step{{Name: "test", Run: "go test ./..."}},
+		Constraints: Constraints{MaxIterations: 5, MaxDiffLines: 100},
+		Rules: []Rule{
+			{Name: "style", Content: "Keep diffs small."},
+			{Name: "go", Content: "Wrap errors.", Globs: []string{"*.go"}},
+		},
+	}
+	err := s.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "rules[1]: ") {
+		t.Fatalf("globs should be rejected for claude rules by list index, got %v", err)
+	}
+	s.Rules = s.Rules[:1]
+	applyDefaults(&s)
+	if s.Rules[0].Target != "claude" {
+		t.Fatalf("want the claude target, got %q", s.Rules[0].Target)
+	}
+}
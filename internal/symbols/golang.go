@@ -0,0 +1,227 @@
+package symbols
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// goProvider outlines Go packages: their exported constants, variables,
+// types, functions and methods. Tests, vendored code and testdata are
+// skipped, as are files that don't parse.
+type goProvider struct{}
+
+func (goProvider) Outline(root string, files []string) (string, error) {
+	byDir := make(map[string][]string)
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".go") || strings.HasSuffix(f, "_test.go") || skipGoPath(f) {
+			continue
+		}
+		dir := path.Dir(f)
+		byDir[dir] = append(byDir[dir], f)
+	}
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var out []string
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		var pkg string
+		var lines []string
+		for _, f := range byDir[dir] {
+			file, err := parser.ParseFile(fset, filepath.Join(root, filepath.FromSlash(f)), nil, parser.SkipObjectResolution)
+			if err != nil {
+				continue
+			}
+			if pkg == "" {
+				pkg = file.Name.Name
+			}
+			lines = append(lines, outlineFile(file)...)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		out = append(out, fmt.Sprintf("package %s (%s)\n  %s", pkg, dir, strings.Join(lines, "\n  ")))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func skipGoPath(f string) bool {
+	for _, part := range strings.Split(path.Dir(f), "/") {
+		if part == "vendor" || part == "testdata" || strings.HasPrefix(part, ".") || strings.HasPrefix(part, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+func outlineFile(file *ast.File) []string {
+	var lines []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.GenDecl:
+			lines = append(lines, outlineGenDecl(d)...)
+		case *ast.FuncDecl:
+			if line, ok := outlineFunc(d); ok {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+func outlineGenDecl(d *ast.GenDecl) []string {
+	switch d.Tok {
+	case token.CONST, token.VAR:
+		var names []string
+		for _, spec := range d.Specs {
+			for _, name := range spec.(*ast.ValueSpec).Names {
+				if name.IsExported() {
+					names = append(names, name.Name)
+				}
+			}
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		return []string{d.Tok.String() + " " + strings.Join(names, ", ")}
+	case token.TYPE:
+		var lines []string
+		for _, spec := range d.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if !ts.Name.IsExported() {
+				continue
+			}
+			head := "type " + ts.Name.Name
+			if ts.TypeParams != nil {
+				head += "[" + fieldList(ts.TypeParams) + "]"
+			}
+			if ts.Assign.IsValid() {
+				head += " ="
+			}
+			lines = append(lines, head+" "+outlineType(ts.Type))
+		}
+		return lines
+	}
+	return nil
+}
+
+// outlineType describes a type: a struct by its exported fields, an
+// interface by its methods, one per line, and anything else as written.
+func outlineType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StructType:
+		var fields []string
+		for _, f := range t.Fields.List {
+			if len(f.Names) == 0 {
+				fields = append(fields, types.ExprString(f.Type))
+				continue
+			}
+			var names []string
+			for _, name := range f.Names {
+				if name.IsExported() {
+					names = append(names, name.Name)
+				}
+			}
+			if len(names) > 0 {
+				fields = append(fields, strings.Join(names, ", ")+" "+types.ExprString(f.Type))
+			}
+		}
+		if len(fields) == 0 {
+			return "struct"
+		}
+		return "struct { " + strings.Join(fields, "; ") + " }"
+	case *ast.InterfaceType:
+		var methods []string
+		for _, m := range t.Methods.List {
+			if len(m.Names) == 0 {
+				methods = append(methods, types.ExprString(m.Type)) // embedded
+				continue
+			}
+			if ft, ok := m.Type.(*ast.FuncType); ok {
+				methods = append(methods, m.Names[0].Name+signature(ft))
+			}
+		}
+		if len(methods) == 0 {
+			return "interface{}"
+		}
+		return "interface {\n      " + strings.Join(methods, "\n      ") + "\n  }"
+	}
+	return types.ExprString(expr)
+}
+
+func outlineFunc(d *ast.FuncDecl) (string, bool) {
+	if !d.Name.IsExported() {
+		return "", false
+	}
+	recv := ""
+	if d.Recv != nil && len(d.Recv.List) > 0 {
+		if !ast.IsExported(receiverType(d.Recv.List[0].Type)) {
+			return "", false
+		}
+		recv = "(" + fieldList(d.Recv) + ") "
+	}
+	name := d.Name.Name
+	if d.Type.TypeParams != nil {
+		name += "[" + fieldList(d.Type.TypeParams) + "]"
+	}
+	return "func " + recv + name + signature(d.Type), true
+}
+
+// receiverType returns the name of a method's receiver base type.
+func receiverType(expr ast.Expr) string {
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.IndexExpr:
+			expr = t.X
+		case *ast.IndexListExpr:
+			expr = t.X
+		case *ast.Ident:
+			return t.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func signature(ft *ast.FuncType) string {
+	s := "(" + fieldList(ft.Params) + ")"
+	if ft.Results == nil || len(ft.Results.List) == 0 {
+		return s
+	}
+	if len(ft.Results.List) == 1 && len(ft.Results.List[0].Names) == 0 {
+		return s + " " + types.ExprString(ft.Results.List[0].Type)
+	}
+	return s + " (" + fieldList(ft.Results) + ")"
+}
+
+func fieldList(fl *ast.FieldList) string {
+	if fl == nil {
+		return ""
+	}
+	var parts []string
+	for _, f := range fl.List {
+		typ := types.ExprString(f.Type)
+		if len(f.Names) == 0 {
+			parts = append(parts, typ)
+			continue
+		}
+		var names []string
+		for _, name := range f.Names {
+			names = append(names, name.Name)
+		}
+		parts = append(parts, strings.Join(names, ", ")+" "+typ)
+	}
+	return strings.Join(parts, ", ")
+}
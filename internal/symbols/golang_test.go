@@ -0,0 +1,91 @@
+package symbols
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoOutline(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"store/store.go": `package store
+
+import "context"
+
+const DefaultLimit = 50
+
+var ErrNotFound, errHidden = 1, 2
+
+type Querier interface {
+	Get(ctx context.Context, id string) (*User, error)
+	Close() error
+}
+
+type User struct {
+	ID, Name string
+	secret   string
+	*Base
+}
+
+type Base struct{}
+
+type Kind string
+
+type Set[T comparable] map[T]struct{}
+
+func New(q Querier) *Store { return nil }
+
+func (s *Store) Get(ctx context.Context, id string) (u *User, err error) { return nil, nil }
+
+func (s *store) Hidden() {}
+
+func helper() {}
+`,
+		"store/store_test.go": "package store\n\nfunc TestX() {}\n",
+		"vendor/x/x.go":       "package x\n\nfunc X() {}\n",
+		"cmd/app/main.go":     "package main\n\nfunc main() {}\n",
+		"broken/broken.go":    "package broken\n\nfunc (",
+		"README.md":           "# readme\n",
+	}
+	var names []string
+	for name, body := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	p, err := Lookup("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Outline(root, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package store (store)
+  const DefaultLimit
+  var ErrNotFound
+  type Querier interface {
+      Get(ctx context.Context, id string) (*User, error)
+      Close() error
+  }
+  type User struct { ID, Name string; *Base }
+  type Base struct
+  type Kind string
+  type Set[T comparable] map[T]struct{}
+  func New(q Querier) *Store
+  func (s *Store) Get(ctx context.Context, id string) (u *User, err error)`
+	if got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+
+	if _, err := Lookup("cobol"); err == nil {
+		t.Fatal("expected an error for an unknown provider")
+	}
+}
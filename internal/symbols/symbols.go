@@ -0,0 +1,40 @@
+// Package symbols outlines the APIs of a repository — packages, exported
+// types and function signatures — so agents can reason about code without
+// reading every file.
+package symbols
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Provider outlines the source files of one language.
+type Provider interface {
+	// Outline returns a compact outline of files, slash-separated paths
+	// relative to root. Files the provider does not handle are ignored.
+	Outline(root string, files []string) (string, error)
+}
+
+var providers = map[string]Provider{
+	"go": goProvider{},
+}
+
+// Lookup returns the provider for a language name as used in
+// context.symbols.
+func Lookup(name string) (Provider, error) {
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("no symbol provider for %q; available: %v", name, Names())
+	}
+	return p, nil
+}
+
+// Names lists the available providers.
+func Names() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}